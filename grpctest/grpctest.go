@@ -18,7 +18,15 @@ import (
 // listening with that server, it will clean up with t.Cleanup or on the given ctx cancel
 // This returns a DialOption that will set the Dialer to connect to this server, for use with NewClient
 func StartTestGRPCTestServer(ctx context.Context, t *testing.T, baseServer *grpc.Server) grpc.DialOption {
+	lis := serveBufconn(ctx, t, baseServer)
+	return grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		// TODO: I'm thinking that injecting a timeout here might be a good idea
+		// if we expanded use of this in a more general way
+		return lis.DialContext(ctx)
+	})
+}
 
+func serveBufconn(ctx context.Context, t *testing.T, baseServer *grpc.Server) *bufconn.Listener {
 	lis := bufconn.Listen(1 << 26)
 
 	var testBlockers sync.WaitGroup
@@ -37,10 +45,5 @@ func StartTestGRPCTestServer(ctx context.Context, t *testing.T, baseServer *grpc
 		defer func() { require.NoError(t, lis.Close()) }()
 		require.NoError(t, baseServer.Serve(lis))
 	}()
-
-	return grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
-		// TODO: I'm thinking that injecting a timeout here might be a good idea
-		// if we expanded use of this in a more general way
-		return lis.DialContext(ctx)
-	})
+	return lis
 }
@@ -0,0 +1,223 @@
+package grpctest
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+// Options for StartHarness
+type HarnessOptions struct {
+	// Run in order, server side ones after fault injection
+	UnaryServerInterceptors  []grpc.UnaryServerInterceptor
+	StreamServerInterceptors []grpc.StreamServerInterceptor
+	UnaryClientInterceptors  []grpc.UnaryClientInterceptor
+	StreamClientInterceptors []grpc.StreamClientInterceptor
+
+	// Use mTLS instead of insecure credentials, see TLSOptions
+	TLS *TLSOptions
+
+	// Anything else, like grpc.MaxRecvMsgSize or a default service config
+	ServerOptions []grpc.ServerOption
+	DialOptions   []grpc.DialOption
+}
+
+/*
+An in-process server and a client connected to it over bufconn, with faults
+that can be injected while the test runs
+
+	h := grpctest.StartHarness(ctx, t, grpctest.HarnessOptions{}, func(s grpc.ServiceRegistrar) {
+		helloworld.RegisterGreeterServer(s, &server{})
+	})
+	h.InjectFault(grpctest.Fault{Method: helloworld.Greeter_SayHello_FullMethodName, Times: 2, Code: codes.Unavailable})
+	client := helloworld.NewGreeterClient(h.Conn)
+*/
+type Harness struct {
+	Server *grpc.Server
+	Conn   *grpc.ClientConn // Closed on test cleanup
+
+	mut    sync.Mutex // Protects the following members
+	faults []*Fault
+	conns  map[*harnessConn]struct{} // Open ones, removed on Close
+}
+
+// Removes itself from the harness's open conns when closed
+type harnessConn struct {
+	net.Conn
+	h *Harness
+}
+
+func (c *harnessConn) Close() error {
+	c.h.mut.Lock()
+	delete(c.h.conns, c)
+	c.h.mut.Unlock()
+	return c.Conn.Close()
+}
+
+// A failure to inject into calls, before they reach the handler
+type Fault struct {
+	// A full method name like "/helloworld.Greeter/SayHello", a service
+	// prefix like "/helloworld.Greeter/", or empty for every method
+	Method string
+	// How many calls to apply to, 0 for every call
+	Times int
+
+	// Delay the call, cut short by the call's deadline
+	Latency time.Duration
+	// Fail the call with this status instead of calling the handler
+	Code    codes.Code
+	Message string
+	// Close the connection, the call fails with Unavailable and the client
+	// has to reconnect
+	DropConnection bool
+}
+
+func (f *Fault) matches(method string) bool {
+	if strings.HasSuffix(f.Method, "/") {
+		return strings.HasPrefix(method, f.Method)
+	}
+	return f.Method == "" || f.Method == method
+}
+
+// Starts a server with the services that register adds, and connects to it
+// The server stops on test cleanup or when ctx is canceled
+func StartHarness(ctx context.Context, t *testing.T, opts HarnessOptions, register func(grpc.ServiceRegistrar)) *Harness {
+	t.Helper()
+	h := &Harness{conns: map[*harnessConn]struct{}{}}
+
+	serverOpts := append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(h.unaryFaultInterceptor),
+		grpc.ChainStreamInterceptor(h.streamFaultInterceptor),
+		grpc.ChainUnaryInterceptor(opts.UnaryServerInterceptors...),
+		grpc.ChainStreamInterceptor(opts.StreamServerInterceptors...),
+	}, opts.ServerOptions...)
+	var credsDialOpt grpc.DialOption = grpc.WithTransportCredentials(insecure.NewCredentials())
+	if opts.TLS != nil {
+		var tlsServerOpt grpc.ServerOption
+		tlsServerOpt, credsDialOpt = TLSCredentials(t, *opts.TLS)
+		serverOpts = append(serverOpts, tlsServerOpt)
+	}
+	h.Server = grpc.NewServer(serverOpts...)
+	register(h.Server)
+	lis := serveBufconn(ctx, t, h.Server)
+
+	dialOpts := append([]grpc.DialOption{
+		credsDialOpt,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			conn, err := lis.DialContext(ctx)
+			if err != nil {
+				return nil, err
+			}
+			hc := &harnessConn{Conn: conn, h: h}
+			h.mut.Lock()
+			h.conns[hc] = struct{}{}
+			h.mut.Unlock()
+			return hc, nil
+		}),
+		grpc.WithChainUnaryInterceptor(opts.UnaryClientInterceptors...),
+		grpc.WithChainStreamInterceptor(opts.StreamClientInterceptors...),
+	}, opts.DialOptions...)
+	conn, err := grpc.NewClient("127.0.0.1", dialOpts...)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	h.Conn = conn
+	return h
+}
+
+// Adds a fault, when several match a call the first one added applies
+func (h *Harness) InjectFault(f Fault) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.faults = append(h.faults, &f)
+}
+
+// Removes all faults
+func (h *Harness) ClearFaults() {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	h.faults = nil
+}
+
+// Closes all client connections, calls in flight fail with Unavailable
+func (h *Harness) DropConnections() {
+	h.mut.Lock()
+	conns := h.conns
+	h.conns = map[*harnessConn]struct{}{}
+	h.mut.Unlock()
+	for conn := range conns {
+		conn.Close()
+	}
+}
+
+// How many client connections are open, closed ones aren't kept
+func (h *Harness) OpenConnections() int {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	return len(h.conns)
+}
+
+// Returns the fault to apply to a call, and uses it up
+func (h *Harness) takeFault(method string) (Fault, bool) {
+	h.mut.Lock()
+	defer h.mut.Unlock()
+	for i, f := range h.faults {
+		if !f.matches(method) {
+			continue
+		}
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				h.faults = append(h.faults[:i:i], h.faults[i+1:]...)
+			}
+		}
+		return *f, true
+	}
+	return Fault{}, false
+}
+
+func (h *Harness) applyFault(ctx context.Context, method string) error {
+	f, ok := h.takeFault(method)
+	if !ok {
+		return nil
+	}
+	if f.Latency > 0 {
+		timer := time.NewTimer(f.Latency)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-timer.C:
+		}
+	}
+	if f.DropConnection {
+		h.DropConnections()
+		return status.Error(codes.Unavailable, "grpctest: dropped connection")
+	}
+	if f.Code != codes.OK {
+		return status.Error(f.Code, f.Message)
+	}
+	return nil
+}
+
+func (h *Harness) unaryFaultInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if err := h.applyFault(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (h *Harness) streamFaultInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := h.applyFault(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
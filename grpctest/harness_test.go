@@ -0,0 +1,109 @@
+package grpctest_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/grpctest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/status"
+)
+
+func startGreeter(t *testing.T, opts grpctest.HarnessOptions) (*grpctest.Harness, helloworld.GreeterClient) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	h := grpctest.StartHarness(ctx, t, opts, func(s grpc.ServiceRegistrar) {
+		helloworld.RegisterGreeterServer(s, &testServer{t: t})
+	})
+	return h, helloworld.NewGreeterClient(h.Conn)
+}
+
+func sayHello(client helloworld.GreeterClient, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	_, err := client.SayHello(ctx, &helloworld.HelloRequest{Name: "myName"})
+	return err
+}
+
+func TestHarnessInterceptors(t *testing.T) {
+	calls := []string{}
+	record := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			calls = append(calls, name+" "+info.FullMethod)
+			return handler(ctx, req)
+		}
+	}
+	_, client := startGreeter(t, grpctest.HarnessOptions{
+		UnaryServerInterceptors: []grpc.UnaryServerInterceptor{record("server1"), record("server2")},
+		UnaryClientInterceptors: []grpc.UnaryClientInterceptor{
+			func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+				calls = append(calls, "client "+method)
+				return invoker(ctx, method, req, reply, cc, opts...)
+			},
+		},
+	})
+	require.NoError(t, sayHello(client, time.Second))
+	require.Equal(t, []string{
+		"client " + helloworld.Greeter_SayHello_FullMethodName,
+		"server1 " + helloworld.Greeter_SayHello_FullMethodName,
+		"server2 " + helloworld.Greeter_SayHello_FullMethodName,
+	}, calls)
+}
+
+func TestHarnessFaults(t *testing.T) {
+	h, client := startGreeter(t, grpctest.HarnessOptions{})
+
+	// Fails the first two calls, like a flaky backend
+	h.InjectFault(grpctest.Fault{Method: "/helloworld.Greeter/", Times: 2, Code: codes.Unavailable, Message: "try again"})
+	for range 2 {
+		err := sayHello(client, time.Second)
+		require.Equal(t, codes.Unavailable, status.Code(err))
+		require.Equal(t, "try again", status.Convert(err).Message())
+	}
+	require.NoError(t, sayHello(client, time.Second))
+
+	// Other methods are unaffected
+	h.InjectFault(grpctest.Fault{Method: "/helloworld.Greeter/Other", Code: codes.Internal})
+	require.NoError(t, sayHello(client, time.Second))
+	h.ClearFaults()
+
+	h.InjectFault(grpctest.Fault{Latency: time.Hour, Times: 1})
+	start := time.Now()
+	err := sayHello(client, 50*time.Millisecond)
+	require.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	require.Less(t, time.Since(start), 10*time.Second)
+
+	h.InjectFault(grpctest.Fault{Latency: 10 * time.Millisecond, Times: 1})
+	require.NoError(t, sayHello(client, time.Second))
+
+	h.InjectFault(grpctest.Fault{DropConnection: true, Times: 1})
+	err = sayHello(client, time.Second)
+	require.Equal(t, codes.Unavailable, status.Code(err))
+	// The client reconnects
+	require.NoError(t, sayHello(client, time.Second))
+}
+
+// Connections the server ages out are closed by the client when it
+// reconnects, and the harness doesn't keep them
+func TestHarnessConnectionsPruned(t *testing.T) {
+	h, client := startGreeter(t, grpctest.HarnessOptions{
+		ServerOptions: []grpc.ServerOption{grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionAge:      10 * time.Millisecond,
+			MaxConnectionAgeGrace: 10 * time.Millisecond,
+		})},
+	})
+	for range 5 {
+		require.Eventually(t, func() bool { return sayHello(client, time.Second) == nil }, 5*time.Second, time.Millisecond)
+		time.Sleep(30 * time.Millisecond)
+	}
+	require.LessOrEqual(t, h.OpenConnections(), 2)
+
+	h.Conn.Close()
+	require.Eventually(t, func() bool { return h.OpenConnections() == 0 }, 5*time.Second, time.Millisecond)
+}
@@ -0,0 +1,209 @@
+package grpctest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/loggingctx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// Incoming metadata used as the request ID when the client sets it
+const RequestIDMetadataKey = "x-request-id"
+
+/*
+Captures what RPC handlers log through loggingctx, writing it to t.Log so
+it stays with the test that caused it, tagged with grpc.method and
+grpc.request_id
+
+	logs := grpctest.NewLogCapture(t)
+	h := grpctest.StartHarness(ctx, t, grpctest.HarnessOptions{
+		UnaryServerInterceptors:  []grpc.UnaryServerInterceptor{logs.UnaryServerInterceptor},
+		StreamServerInterceptors: []grpc.StreamServerInterceptor{logs.StreamServerInterceptor},
+	}, register)
+	...
+	logs.RequireLine(slog.LevelInfo, "said hello", "name", "myName")
+*/
+type LogCapture struct {
+	t testing.TB
+
+	mut       sync.Mutex // Protects the following members
+	records   []CapturedRecord
+	lastID    uint64
+	testEnded bool // t.Log panics after the test, handlers can outlive it
+}
+
+// A record logged during an RPC
+type CapturedRecord struct {
+	Method    string
+	RequestID string
+	Level     slog.Level
+	Message   string
+	// Decoded from JSON, so numbers are float64 and groups are maps
+	Attrs map[string]any
+	Line  string // As written to t.Log
+}
+
+func NewLogCapture(t testing.TB) *LogCapture {
+	c := &LogCapture{t: t}
+	t.Cleanup(func() {
+		c.mut.Lock()
+		defer c.mut.Unlock()
+		c.testEnded = true
+	})
+	return c
+}
+
+// Returns ctx with a handler for one RPC installed
+func (c *LogCapture) rpcContext(ctx context.Context, method string) context.Context {
+	c.mut.Lock()
+	c.lastID++
+	requestID := fmt.Sprint(c.lastID)
+	c.mut.Unlock()
+	md, _ := metadata.FromIncomingContext(ctx)
+	if ids := md.Get(RequestIDMetadataKey); len(ids) > 0 {
+		requestID = ids[0]
+	}
+
+	h := &captureHandler{c: c, method: method, requestID: requestID}
+	return loggingctx.Context(ctx, h.WithAttrs([]slog.Attr{
+		slog.String("grpc.method", method),
+		slog.String("grpc.request_id", requestID),
+	}))
+}
+
+func (c *LogCapture) UnaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	return handler(c.rpcContext(ctx, info.FullMethod), req)
+}
+
+func (c *LogCapture) StreamServerInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &contextStream{ServerStream: ss, ctx: c.rpcContext(ss.Context(), info.FullMethod)})
+}
+
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// Everything captured so far
+func (c *LogCapture) Records() []CapturedRecord {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return append([]CapturedRecord{}, c.records...)
+}
+
+// Requires that some RPC logged a record with exactly these attributes, and
+// returns it, the grpc.method and grpc.request_id tags are not compared
+func (c *LogCapture) RequireLine(expectedLevel slog.Level, expectedMsg string, expectedArgs ...any) CapturedRecord {
+	c.t.Helper()
+
+	expectedBuf := bytes.Buffer{}
+	slog.New(slog.NewJSONHandler(&expectedBuf, &slog.HandlerOptions{Level: expectedLevel})).
+		Log(context.Background(), expectedLevel, expectedMsg, expectedArgs...)
+	expected := map[string]any{}
+	require.NoError(c.t, json.Unmarshal(expectedBuf.Bytes(), &expected))
+	delete(expected, "time")
+	delete(expected, "level")
+	delete(expected, "msg")
+
+	lines := []string{}
+	for _, r := range c.Records() {
+		if r.Level == expectedLevel && r.Message == expectedMsg && equalJSON(r.Attrs, expected) {
+			return r
+		}
+		lines = append(lines, r.Line)
+	}
+	require.Failf(c.t, "log line not found",
+		"expected: %s\ncaptured:\n%s", strings.TrimSpace(expectedBuf.String()), strings.Join(lines, "\n"))
+	return CapturedRecord{}
+}
+
+func equalJSON(a, b map[string]any) bool {
+	ab, _ := json.Marshal(a)
+	bb, _ := json.Marshal(b)
+	return bytes.Equal(ab, bb)
+}
+
+// Formats records twice, as text for t.Log and as JSON for CapturedRecord
+type captureHandler struct {
+	c         *LogCapture
+	method    string
+	requestID string
+	// WithAttrs and WithGroup calls, in order, to replay on the formatters
+	ops []func(slog.Handler) slog.Handler
+}
+
+func (h *captureHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *captureHandler) Handle(ctx context.Context, r slog.Record) error {
+	noTime := &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}
+	text, js := bytes.Buffer{}, bytes.Buffer{}
+	var textHandler, jsonHandler slog.Handler = slog.NewTextHandler(&text, noTime), slog.NewJSONHandler(&js, noTime)
+	for _, op := range h.ops {
+		textHandler, jsonHandler = op(textHandler), op(jsonHandler)
+	}
+	if err := textHandler.Handle(ctx, r); err != nil {
+		return err
+	}
+	if err := jsonHandler.Handle(ctx, r); err != nil {
+		return err
+	}
+
+	attrs := map[string]any{}
+	if err := json.Unmarshal(js.Bytes(), &attrs); err != nil {
+		return err
+	}
+	for _, key := range []string{slog.LevelKey, slog.MessageKey, "grpc.method", "grpc.request_id"} {
+		delete(attrs, key)
+	}
+	record := CapturedRecord{
+		Method:    h.method,
+		RequestID: h.requestID,
+		Level:     r.Level,
+		Message:   r.Message,
+		Attrs:     attrs,
+		Line:      strings.TrimSuffix(text.String(), "\n"),
+	}
+
+	h.c.mut.Lock()
+	defer h.c.mut.Unlock()
+	h.c.records = append(h.c.records, record)
+	if !h.c.testEnded {
+		h.c.t.Log(record.Line)
+	}
+	return nil
+}
+
+func (h *captureHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	r := *h
+	r.ops = append(r.ops[:len(r.ops):len(r.ops)], func(s slog.Handler) slog.Handler { return s.WithAttrs(attrs) })
+	return &r
+}
+
+func (h *captureHandler) WithGroup(name string) slog.Handler {
+	r := *h
+	r.ops = append(r.ops[:len(r.ops):len(r.ops)], func(s slog.Handler) slog.Handler { return s.WithGroup(name) })
+	return &r
+}
@@ -0,0 +1,75 @@
+package grpctest_test
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/grpctest"
+	"gitlab.com/croepha/common-utils/loggingctx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/examples/features/proto/echo"
+	"google.golang.org/grpc/metadata"
+)
+
+type loggingEchoServer struct {
+	echo.UnimplementedEchoServer
+}
+
+func (loggingEchoServer) UnaryEcho(ctx context.Context, req *echo.EchoRequest) (*echo.EchoResponse, error) {
+	loggingctx.Info(ctx, "echoing", "message", req.Message)
+	slog.New(loggingctx.Handler(ctx)).WithGroup("stats").Debug("echoed", "bytes", len(req.Message))
+	return &echo.EchoResponse{Message: req.Message}, nil
+}
+
+func (loggingEchoServer) ServerStreamingEcho(req *echo.EchoRequest, stream grpc.ServerStreamingServer[echo.EchoResponse]) error {
+	loggingctx.Warn(stream.Context(), "streaming", "message", req.Message)
+	return stream.Send(&echo.EchoResponse{Message: req.Message})
+}
+
+func TestLogCapture(t *testing.T) {
+	for _, name := range []string{"a", "b"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			logs := grpctest.NewLogCapture(t)
+			h := grpctest.StartHarness(ctx, t, grpctest.HarnessOptions{
+				UnaryServerInterceptors:  []grpc.UnaryServerInterceptor{logs.UnaryServerInterceptor},
+				StreamServerInterceptors: []grpc.StreamServerInterceptor{logs.StreamServerInterceptor},
+			}, func(s grpc.ServiceRegistrar) {
+				echo.RegisterEchoServer(s, loggingEchoServer{})
+			})
+			client := echo.NewEchoClient(h.Conn)
+
+			_, err := client.UnaryEcho(ctx, &echo.EchoRequest{Message: name})
+			require.NoError(t, err)
+			r := logs.RequireLine(slog.LevelInfo, "echoing", "message", name)
+			require.Equal(t, echo.Echo_UnaryEcho_FullMethodName, r.Method)
+			require.Equal(t, "1", r.RequestID)
+			require.Equal(t, `level=INFO msg=echoing grpc.method=/grpc.examples.echo.Echo/UnaryEcho grpc.request_id=1 message=`+name, r.Line)
+			r = logs.RequireLine(slog.LevelDebug, "echoed", slog.Group("stats", "bytes", 1))
+			require.Equal(t, "1", r.RequestID)
+
+			stream, err := client.ServerStreamingEcho(
+				metadata.AppendToOutgoingContext(ctx, grpctest.RequestIDMetadataKey, "req-"+name),
+				&echo.EchoRequest{Message: name})
+			require.NoError(t, err)
+			_, err = stream.Recv()
+			require.NoError(t, err)
+			r = logs.RequireLine(slog.LevelWarn, "streaming", "message", name)
+			require.Equal(t, echo.Echo_ServerStreamingEcho_FullMethodName, r.Method)
+			require.Equal(t, "req-"+name, r.RequestID)
+
+			// Only this test's records
+			requestIDs := []string{}
+			for _, r := range logs.Records() {
+				requestIDs = append(requestIDs, r.RequestID)
+			}
+			require.Equal(t, []string{"1", "1", "req-" + name}, requestIDs)
+		})
+	}
+}
@@ -0,0 +1,351 @@
+package grpctest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// Golden file format, a JSON list of these
+type recordedCall struct {
+	Method   string              `json:"method"`
+	Metadata map[string][]string `json:"metadata,omitempty"`
+	// In the order the client saw them, which is an order the server can
+	// replay them in
+	Events []recordedEvent `json:"events"`
+	Status recordedStatus  `json:"status"`
+}
+
+// One of Request or Response, as protojson
+type recordedEvent struct {
+	Request  json.RawMessage `json:"request,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+}
+
+type recordedStatus struct {
+	Code    string `json:"code"` // Like "NotFound"
+	Message string `json:"message,omitempty"`
+}
+
+func newRecordedStatus(err error) recordedStatus {
+	s := status.Convert(err)
+	return recordedStatus{Code: s.Code().String(), Message: s.Message()}
+}
+
+func (s recordedStatus) err() error {
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if c.String() == s.Code {
+			return status.Error(c, s.Message)
+		}
+	}
+	return status.Errorf(codes.Internal, "grpctest replay: unknown code %+q", s.Code)
+}
+
+// Set to 1 to have Recorders write their golden files, instead of comparing
+// the calls against them
+const UpdateGoldenEnv = "GRPCTEST_UPDATE"
+
+/*
+Records the calls made through its client interceptors, and on test cleanup
+compares them with a golden file, for NewReplayServer.  Run the test with
+GRPCTEST_UPDATE=1 to write the golden file instead
+
+	rec := grpctest.NewRecorder(t, "testdata/greeter.json")
+	h := grpctest.StartHarness(ctx, t, grpctest.HarnessOptions{
+		UnaryClientInterceptors:  []grpc.UnaryClientInterceptor{rec.UnaryClientInterceptor},
+		StreamClientInterceptors: []grpc.StreamClientInterceptor{rec.StreamClientInterceptor},
+	}, register)
+
+Streams have to be read to the end, or have their context cancelled,
+before the test ends, so that their status is known
+*/
+type Recorder struct {
+	t    testing.TB
+	path string
+
+	mut   sync.Mutex // Protects the following members
+	calls []*recordedCall
+}
+
+func NewRecorder(t testing.TB, path string) *Recorder {
+	r := &Recorder{t: t, path: path}
+	t.Cleanup(func() {
+		if err := r.finishRecording(); err != nil {
+			t.Errorf("grpctest record: %s", err)
+		}
+	})
+	return r
+}
+
+func (r *Recorder) finishRecording() error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	for _, call := range r.calls {
+		if call.Status.Code == "" {
+			return fmt.Errorf("%s: a %s stream was still open, read it to the end or cancel it", r.path, call.Method)
+		}
+	}
+	b, err := json.MarshalIndent(r.calls, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+
+	if os.Getenv(UpdateGoldenEnv) != "1" {
+		golden, err := os.ReadFile(r.path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("%w, run with %s=1 to create it", err, UpdateGoldenEnv)
+		}
+		if err != nil {
+			return err
+		}
+		assert.Equal(r.t, string(golden), string(b),
+			"grpctest record: calls differ from %s, run with %s=1 to update it", r.path, UpdateGoldenEnv)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, b, 0644)
+}
+
+// Calls are written in the order they start
+func (r *Recorder) start(ctx context.Context, method string) *recordedCall {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	call := &recordedCall{Method: method, Metadata: md, Events: []recordedEvent{}}
+	r.mut.Lock()
+	r.calls = append(r.calls, call)
+	r.mut.Unlock()
+	return call
+}
+
+func (r *Recorder) addEvent(call *recordedCall, m any, isRequest bool) {
+	msg, ok := m.(proto.Message)
+	if !ok {
+		r.t.Errorf("grpctest record %s: %T is not a proto.Message", call.Method, m)
+		return
+	}
+	b, err := protojson.Marshal(msg)
+	if err != nil {
+		r.t.Errorf("grpctest record %s: %s", call.Method, err)
+		return
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if isRequest {
+		call.Events = append(call.Events, recordedEvent{Request: b})
+	} else {
+		call.Events = append(call.Events, recordedEvent{Response: b})
+	}
+}
+
+// The first status wins, later ones are the same call failing again
+func (r *Recorder) finish(call *recordedCall, err error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if call.Status.Code == "" {
+		call.Status = newRecordedStatus(err)
+	}
+}
+
+func (r *Recorder) UnaryClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	call := r.start(ctx, method)
+	r.addEvent(call, req, true)
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err == nil {
+		r.addEvent(call, reply, false)
+	}
+	r.finish(call, err)
+	return err
+}
+
+func (r *Recorder) StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	call := r.start(ctx, method)
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		r.finish(call, err)
+		return nil, err
+	}
+	// Streams that are cancelled before they are read to the end
+	stopAfter := context.AfterFunc(ctx, func() {
+		r.finish(call, status.FromContextError(ctx.Err()).Err())
+	})
+	return &recordingStream{ClientStream: stream, r: r, call: call, serverStreams: desc.ServerStreams, stopAfter: stopAfter}, nil
+}
+
+type recordingStream struct {
+	grpc.ClientStream
+	r             *Recorder
+	call          *recordedCall
+	serverStreams bool
+	stopAfter     func() bool // Unregisters the cancel watch on ctx
+}
+
+func (s *recordingStream) finish(err error) {
+	s.stopAfter()
+	s.r.finish(s.call, err)
+}
+
+func (s *recordingStream) SendMsg(m any) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		s.r.addEvent(s.call, m, true)
+	}
+	return err
+}
+
+func (s *recordingStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err == nil {
+		s.r.addEvent(s.call, m, false)
+		if !s.serverStreams {
+			// The only response, the stream has finished
+			s.finish(nil)
+		}
+	} else if err == io.EOF {
+		s.finish(nil)
+	} else {
+		s.finish(err)
+	}
+	return err
+}
+
+/*
+Returns a fake server that answers with the calls in a golden file written by
+a Recorder, for StartTestGRPCTestServer
+Calls to each method are replayed in the order they were recorded, requests
+and metadata that differ from the recording fail the test, as do recorded
+calls that weren't made by test cleanup
+The services' protos have to be linked in, so their descriptors are registered
+*/
+func NewReplayServer(t testing.TB, path string) *grpc.Server {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("grpctest replay: %s", err)
+	}
+	calls := []*recordedCall{}
+	if err := json.Unmarshal(b, &calls); err != nil {
+		t.Fatalf("grpctest replay: %s: %s", path, err)
+	}
+	r := &replayer{t: t, path: path, calls: calls}
+	t.Cleanup(r.checkAllReplayed)
+	return grpc.NewServer(grpc.UnknownServiceHandler(r.handle))
+}
+
+type replayer struct {
+	t    testing.TB
+	path string
+
+	mut   sync.Mutex // Protects the following members
+	calls []*recordedCall
+}
+
+// Removes and returns the next recorded call of method
+func (r *replayer) take(method string) *recordedCall {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	for i, call := range r.calls {
+		if call.Method == method {
+			r.calls = append(r.calls[:i:i], r.calls[i+1:]...)
+			return call
+		}
+	}
+	return nil
+}
+
+func (r *replayer) checkAllReplayed() {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	if len(r.calls) > 0 {
+		r.t.Errorf("grpctest replay: %s: %d recorded calls were not made, the first is %s",
+			r.path, len(r.calls), r.calls[0].Method)
+	}
+}
+
+// Fails the test, and the call with an Internal status
+func (r *replayer) fail(method string, format string, args ...any) error {
+	msg := fmt.Sprintf(format, args...)
+	r.t.Errorf("grpctest replay %s: %s", method, msg)
+	return status.Errorf(codes.Internal, "grpctest replay: %s", msg)
+}
+
+func (r *replayer) handle(_ any, stream grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(stream)
+	call := r.take(method)
+	if call == nil {
+		return r.fail(method, "no more recorded calls in %s", r.path)
+	}
+	desc, err := findMethod(method)
+	if err != nil {
+		return r.fail(method, "%s", err)
+	}
+
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	for key, want := range call.Metadata {
+		if got := md.Get(key); !slices.Equal(got, want) {
+			return r.fail(method, "metadata %s is %q, recorded %q", key, got, want)
+		}
+	}
+
+	requests := 0
+	for _, event := range call.Events {
+		if event.Request != nil {
+			got := dynamicpb.NewMessage(desc.Input())
+			if err := stream.RecvMsg(got); err != nil {
+				return r.fail(method, "receiving request %d: %s", requests, err)
+			}
+			want := dynamicpb.NewMessage(desc.Input())
+			if err := protojson.Unmarshal(event.Request, want); err != nil {
+				return r.fail(method, "recorded request %d: %s", requests, err)
+			}
+			if !proto.Equal(got, want) {
+				return r.fail(method, "request %d differs\n got: %s\nwant: %s",
+					requests, protojson.Format(got), protojson.Format(want))
+			}
+			requests++
+			continue
+		}
+		resp := dynamicpb.NewMessage(desc.Output())
+		if err := protojson.Unmarshal(event.Response, resp); err != nil {
+			return r.fail(method, "recorded response: %s", err)
+		}
+		if err := stream.SendMsg(resp); err != nil {
+			return err
+		}
+	}
+	return call.Status.err()
+}
+
+// Finds the descriptor of a method like "/helloworld.Greeter/SayHello"
+func findMethod(method string) (protoreflect.MethodDescriptor, error) {
+	name := protoreflect.FullName(strings.ReplaceAll(strings.TrimPrefix(method, "/"), "/", "."))
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	desc, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a method", name)
+	}
+	return desc, nil
+}
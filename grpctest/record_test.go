@@ -0,0 +1,304 @@
+package grpctest_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/grpctest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/examples/features/proto/echo"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type echoServer struct {
+	echo.UnimplementedEchoServer
+}
+
+func (echoServer) UnaryEcho(ctx context.Context, req *echo.EchoRequest) (*echo.EchoResponse, error) {
+	if req.Message == "" {
+		return nil, status.Error(codes.InvalidArgument, "empty message")
+	}
+	return &echo.EchoResponse{Message: req.Message}, nil
+}
+
+func (echoServer) ServerStreamingEcho(req *echo.EchoRequest, stream grpc.ServerStreamingServer[echo.EchoResponse]) error {
+	for _, word := range strings.Fields(req.Message) {
+		if err := stream.Send(&echo.EchoResponse{Message: word}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (echoServer) ClientStreamingEcho(stream grpc.ClientStreamingServer[echo.EchoRequest, echo.EchoResponse]) error {
+	words := []string{}
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&echo.EchoResponse{Message: strings.Join(words, " ")})
+		}
+		if err != nil {
+			return err
+		}
+		words = append(words, req.Message)
+	}
+}
+
+func (echoServer) BidirectionalStreamingEcho(stream grpc.BidiStreamingServer[echo.EchoRequest, echo.EchoResponse]) error {
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&echo.EchoResponse{Message: req.Message}); err != nil {
+			return err
+		}
+	}
+}
+
+// Makes one call of each kind, and checks the responses
+func exerciseEcho(t *testing.T, client echo.EchoClient) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := client.UnaryEcho(metadata.AppendToOutgoingContext(ctx, "tenant", "a"), &echo.EchoRequest{Message: "hi"})
+	require.NoError(t, err)
+	require.Equal(t, "hi", resp.Message)
+
+	_, err = client.UnaryEcho(ctx, &echo.EchoRequest{})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+	require.Equal(t, "empty message", status.Convert(err).Message())
+
+	server, err := client.ServerStreamingEcho(ctx, &echo.EchoRequest{Message: "a b"})
+	require.NoError(t, err)
+	for _, want := range []string{"a", "b"} {
+		resp, err := server.Recv()
+		require.NoError(t, err)
+		require.Equal(t, want, resp.Message)
+	}
+	_, err = server.Recv()
+	require.Equal(t, io.EOF, err)
+
+	clientStream, err := client.ClientStreamingEcho(ctx)
+	require.NoError(t, err)
+	require.NoError(t, clientStream.Send(&echo.EchoRequest{Message: "c"}))
+	require.NoError(t, clientStream.Send(&echo.EchoRequest{Message: "d"}))
+	resp, err = clientStream.CloseAndRecv()
+	require.NoError(t, err)
+	require.Equal(t, "c d", resp.Message)
+
+	bidi, err := client.BidirectionalStreamingEcho(ctx)
+	require.NoError(t, err)
+	for _, msg := range []string{"e", "f"} {
+		require.NoError(t, bidi.Send(&echo.EchoRequest{Message: msg}))
+		resp, err := bidi.Recv()
+		require.NoError(t, err)
+		require.Equal(t, msg, resp.Message)
+	}
+	require.NoError(t, bidi.CloseSend())
+	_, err = bidi.Recv()
+	require.Equal(t, io.EOF, err)
+}
+
+func dialReplay(t *testing.T, server *grpc.Server) echo.EchoClient {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	conn, err := grpc.NewClient("127.0.0.1",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpctest.StartTestGRPCTestServer(ctx, t, server),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return echo.NewEchoClient(conn)
+}
+
+// Starts an echo server behind the recorder's interceptors
+func recordEcho(t *testing.T, rec *grpctest.Recorder) echo.EchoClient {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	h := grpctest.StartHarness(ctx, t, grpctest.HarnessOptions{
+		UnaryClientInterceptors:  []grpc.UnaryClientInterceptor{rec.UnaryClientInterceptor},
+		StreamClientInterceptors: []grpc.StreamClientInterceptor{rec.StreamClientInterceptor},
+	}, func(s grpc.ServiceRegistrar) {
+		echo.RegisterEchoServer(s, echoServer{})
+	})
+	return echo.NewEchoClient(h.Conn)
+}
+
+func TestRecordReplay(t *testing.T) {
+	t.Run("record", func(t *testing.T) {
+		exerciseEcho(t, recordEcho(t, grpctest.NewRecorder(t, "testdata/echo.json")))
+	})
+	t.Run("replay", func(t *testing.T) {
+		exerciseEcho(t, dialReplay(t, grpctest.NewReplayServer(t, "testdata/echo.json")))
+	})
+}
+
+func TestRecordUpdate(t *testing.T) {
+	t.Setenv(grpctest.UpdateGoldenEnv, "1")
+	golden := filepath.Join(t.TempDir(), "testdata", "echo.json")
+	t.Run("record", func(t *testing.T) {
+		exerciseEcho(t, recordEcho(t, grpctest.NewRecorder(t, golden)))
+	})
+
+	recorded, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	want, err := os.ReadFile("testdata/echo.json")
+	require.NoError(t, err)
+	require.Equal(t, string(want), string(recorded))
+}
+
+func TestRecordMismatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	capture := &failureCapture{}
+
+	t.Run("differs", func(t *testing.T) {
+		capture.TB = t
+		client := recordEcho(t, grpctest.NewRecorder(capture, "testdata/echo.json"))
+		_, err := client.UnaryEcho(ctx, &echo.EchoRequest{Message: "bye"})
+		require.NoError(t, err)
+	})
+	t.Run("missing", func(t *testing.T) {
+		capture.TB = t
+		recordEcho(t, grpctest.NewRecorder(capture, filepath.Join(t.TempDir(), "echo.json")))
+	})
+	t.Run("open stream", func(t *testing.T) {
+		t.Setenv(grpctest.UpdateGoldenEnv, "1")
+		capture.TB = t
+		golden := filepath.Join(t.TempDir(), "echo.json")
+		client := recordEcho(t, grpctest.NewRecorder(capture, golden))
+		stream, err := client.ServerStreamingEcho(ctx, &echo.EchoRequest{Message: "a b"})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.NoError(t, err)
+	})
+
+	require.Len(t, capture.messages, 3)
+	require.Contains(t, capture.messages[0], "calls differ from testdata/echo.json")
+	require.Contains(t, capture.messages[1], "no such file or directory, run with GRPCTEST_UPDATE=1 to create it")
+	require.Contains(t, capture.messages[2], "a /grpc.examples.echo.Echo/ServerStreamingEcho stream was still open")
+}
+
+func TestRecordCancelledStream(t *testing.T) {
+	t.Setenv(grpctest.UpdateGoldenEnv, "1")
+	golden := filepath.Join(t.TempDir(), "echo.json")
+	t.Run("record", func(t *testing.T) {
+		client := recordEcho(t, grpctest.NewRecorder(t, golden))
+		ctx, cancel := context.WithCancel(context.Background())
+		stream, err := client.ServerStreamingEcho(ctx, &echo.EchoRequest{Message: "a b"})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.NoError(t, err)
+		cancel()
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		client := dialReplay(t, grpctest.NewReplayServer(t, golden))
+		stream, err := client.ServerStreamingEcho(context.Background(), &echo.EchoRequest{Message: "a b"})
+		require.NoError(t, err)
+		resp, err := stream.Recv()
+		require.NoError(t, err)
+		require.Equal(t, "a", resp.Message)
+		_, err = stream.Recv()
+		require.Equal(t, codes.Canceled, status.Code(err))
+	})
+}
+
+// A context that is never done, and counts the context.AfterFunc
+// registrations on it that haven't been stopped
+type afterFuncCounter struct {
+	context.Context
+	done   chan struct{}
+	active atomic.Int32
+}
+
+func (c *afterFuncCounter) Done() <-chan struct{} { return c.done }
+
+func (c *afterFuncCounter) AfterFunc(f func()) func() bool {
+	c.active.Add(1)
+	stopped := atomic.Bool{}
+	return func() bool {
+		if stopped.Swap(true) {
+			return false
+		}
+		c.active.Add(-1)
+		return true
+	}
+}
+
+// Streams that finish stop watching their context, a long lived one
+// doesn't collect a watch for every stream
+func TestRecordStopsWatchingContext(t *testing.T) {
+	t.Setenv(grpctest.UpdateGoldenEnv, "1")
+	client := recordEcho(t, grpctest.NewRecorder(t, filepath.Join(t.TempDir(), "echo.json")))
+	ctx := &afterFuncCounter{Context: context.Background(), done: make(chan struct{})}
+	for range 3 {
+		stream, err := client.ServerStreamingEcho(ctx, &echo.EchoRequest{Message: "a"})
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.NoError(t, err)
+		_, err = stream.Recv()
+		require.Equal(t, io.EOF, err)
+	}
+	require.Zero(t, ctx.active.Load())
+}
+
+// Captures the failures of a replay server instead of failing the test
+type failureCapture struct {
+	testing.TB
+	mut      sync.Mutex
+	failures []string
+	// The whole messages, testify's start with a newline
+	messages []string
+}
+
+func (f *failureCapture) Errorf(format string, args ...any) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	msg := fmt.Sprintf(format, args...)
+	f.failures = append(f.failures, strings.SplitN(msg, "\n", 2)[0])
+	f.messages = append(f.messages, msg)
+}
+
+func TestReplayMismatch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	capture := &failureCapture{}
+	t.Run("calls", func(t *testing.T) {
+		capture.TB = t
+		client := dialReplay(t, grpctest.NewReplayServer(capture, "testdata/echo.json"))
+		_, err := client.UnaryEcho(metadata.AppendToOutgoingContext(ctx, "tenant", "a"), &echo.EchoRequest{Message: "bye"})
+		require.Equal(t, codes.Internal, status.Code(err))
+		_, err = client.UnaryEcho(ctx, &echo.EchoRequest{})
+		require.Equal(t, codes.InvalidArgument, status.Code(err))
+		_, err = client.UnaryEcho(ctx, &echo.EchoRequest{})
+		require.Equal(t, codes.Internal, status.Code(err))
+
+		client = dialReplay(t, grpctest.NewReplayServer(capture, "testdata/echo.json"))
+		_, err = client.UnaryEcho(ctx, &echo.EchoRequest{Message: "hi"})
+		require.Equal(t, codes.Internal, status.Code(err))
+	})
+	require.Equal(t, []string{
+		"grpctest replay /grpc.examples.echo.Echo/UnaryEcho: request 0 differs",
+		"grpctest replay /grpc.examples.echo.Echo/UnaryEcho: no more recorded calls in testdata/echo.json",
+		`grpctest replay /grpc.examples.echo.Echo/UnaryEcho: metadata tenant is [], recorded ["a"]`,
+		"grpctest replay: testdata/echo.json: 4 recorded calls were not made, the first is /grpc.examples.echo.Echo/UnaryEcho",
+		"grpctest replay: testdata/echo.json: 3 recorded calls were not made, the first is /grpc.examples.echo.Echo/ServerStreamingEcho",
+	}, capture.failures)
+}
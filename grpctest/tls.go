@@ -0,0 +1,62 @@
+package grpctest
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/tlscreds"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// Options for running tests over mTLS instead of insecure
+type TLSOptions struct {
+	// Supplied credentials, like from tlscreds.LoadServerCredentials, when
+	// either is nil it is generated from tlscreds.SharedDevCA, so supplied
+	// credentials need to trust that CA to talk to generated ones
+	ServerCreds credentials.TransportCredentials
+	ClientCreds credentials.TransportCredentials
+
+	// What to issue generated certificates for, the server's is always valid
+	// for 127.0.0.1 (the address the client dials) and the CommonNames
+	// default to "grpctest server" and "grpctest client"
+	Server tlscreds.LeafOptions
+	Client tlscreds.LeafOptions
+}
+
+// Returns the options that make a server and a client use mTLS, for use
+// with grpc.NewServer and StartTestGRPCTestServer
+// Generated credentials are closed on test cleanup
+func TLSCredentials(t *testing.T, opts TLSOptions) (grpc.ServerOption, grpc.DialOption) {
+	t.Helper()
+	serverCreds, clientCreds := opts.ServerCreds, opts.ClientCreds
+	if serverCreds == nil || clientCreds == nil {
+		ca, err := tlscreds.SharedDevCA()
+		require.NoError(t, err)
+
+		server := opts.Server
+		if server.CommonName == "" {
+			server.CommonName = "grpctest server"
+		}
+		server.IPAddresses = append(server.IPAddresses[:len(server.IPAddresses):len(server.IPAddresses)], net.ParseIP("127.0.0.1"))
+		client := opts.Client
+		if client.CommonName == "" {
+			client.CommonName = "grpctest client"
+		}
+		generatedServer, generatedClient, err := ca.Credentials(server, client)
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			generatedServer.Close()
+			generatedClient.Close()
+		})
+
+		if serverCreds == nil {
+			serverCreds = generatedServer
+		}
+		if clientCreds == nil {
+			clientCreds = generatedClient
+		}
+	}
+	return grpc.Creds(serverCreds), grpc.WithTransportCredentials(clientCreds)
+}
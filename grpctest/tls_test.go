@@ -0,0 +1,89 @@
+package grpctest_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/grpctest"
+	"gitlab.com/croepha/common-utils/tlscreds"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/examples/helloworld/helloworld"
+	"google.golang.org/grpc/status"
+)
+
+type peerServer struct {
+	helloworld.UnimplementedGreeterServer
+}
+
+func (peerServer) SayHello(ctx context.Context, req *helloworld.HelloRequest) (*helloworld.HelloReply, error) {
+	id, err := tlscreds.PeerIdentityFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &helloworld.HelloReply{Message: "hello " + id.CommonName}, nil
+}
+
+func TestHarnessTLS(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	policy, err := tlscreds.Allowlist{CommonNames: []string{"api"}}.Policy()
+	require.NoError(t, err)
+	h := grpctest.StartHarness(ctx, t, grpctest.HarnessOptions{
+		TLS: &grpctest.TLSOptions{Client: tlscreds.LeafOptions{CommonName: "api"}},
+		UnaryServerInterceptors: []grpc.UnaryServerInterceptor{
+			tlscreds.UnaryServerInterceptor(policy),
+		},
+	}, func(s grpc.ServiceRegistrar) {
+		helloworld.RegisterGreeterServer(s, peerServer{})
+	})
+	resp, err := helloworld.NewGreeterClient(h.Conn).SayHello(ctx, &helloworld.HelloRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "hello api", resp.Message)
+
+	h = grpctest.StartHarness(ctx, t, grpctest.HarnessOptions{
+		TLS: &grpctest.TLSOptions{Client: tlscreds.LeafOptions{CommonName: "batch"}},
+		UnaryServerInterceptors: []grpc.UnaryServerInterceptor{
+			tlscreds.UnaryServerInterceptor(policy),
+		},
+	}, func(s grpc.ServiceRegistrar) {
+		helloworld.RegisterGreeterServer(s, peerServer{})
+	})
+	_, err = helloworld.NewGreeterClient(h.Conn).SayHello(ctx, &helloworld.HelloRequest{})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestSuppliedTLSCredentials(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ca, err := tlscreds.NewDevCA(t.Name())
+	require.NoError(t, err)
+	serverCreds, clientCreds, err := ca.Credentials(
+		tlscreds.LeafOptions{CommonName: "server", IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}},
+		tlscreds.LeafOptions{CommonName: "client"},
+	)
+	require.NoError(t, err)
+	serverOpt, dialOpt := grpctest.TLSCredentials(t, grpctest.TLSOptions{ServerCreds: serverCreds, ClientCreds: clientCreds})
+
+	server := grpc.NewServer(serverOpt)
+	helloworld.RegisterGreeterServer(server, peerServer{})
+	conn, err := grpc.NewClient("127.0.0.1", dialOpt, grpctest.StartTestGRPCTestServer(ctx, t, server))
+	require.NoError(t, err)
+	defer conn.Close()
+	resp, err := helloworld.NewGreeterClient(conn).SayHello(ctx, &helloworld.HelloRequest{})
+	require.NoError(t, err)
+	require.Equal(t, "hello client", resp.Message)
+
+	// A client from another CA is rejected in the handshake
+	_, otherClient := grpctest.TLSCredentials(t, grpctest.TLSOptions{ServerCreds: serverCreds})
+	conn, err = grpc.NewClient("127.0.0.1", otherClient, grpctest.StartTestGRPCTestServer(ctx, t, server))
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = helloworld.NewGreeterClient(conn).SayHello(ctx, &helloworld.HelloRequest{})
+	require.Equal(t, codes.Unavailable, status.Code(err))
+}
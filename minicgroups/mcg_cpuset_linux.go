@@ -0,0 +1,233 @@
+package minicgroups
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Current cgroup.type of the group: domain, threaded, "domain threaded" or "domain invalid"
+func (cg *group) Type(ctx context.Context) (string, error) {
+	if cg.path == "" {
+		panic("cgroup not opened")
+	}
+	fb, err := cg.fs.ReadFile(cg.path + "/cgroup.type")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(fb)), nil
+}
+
+// Turns the group into a threaded group, so individual threads can be moved
+// into it with AddThread.  This can't be undone
+func (cg *group) SetThreaded(ctx context.Context) error {
+	if cg.path == "" {
+		panic("cgroup not opened")
+	}
+	l.A("mcgPath", cg.path).Debug(ctx, "mcg SetThreaded")
+	return cg.fs.WriteFile(cg.path+"/cgroup.type", []byte("threaded"))
+}
+
+// Tids of the threads that are members of this group (cgroup.threads)
+func (cg *group) Threads(ctx context.Context) ([]int, error) {
+	if cg.path == "" {
+		panic("cgroup not opened")
+	}
+	return readPidList(cg.fs, cg.path+"/cgroup.threads")
+}
+
+// Moves a single thread into the group
+// The group must be threaded, and the thread's process must already be in
+// the group's threaded domain
+func (cg *group) AddThread(ctx context.Context, tid int) error {
+	if cg.path == "" {
+		panic("cgroup not opened")
+	}
+	return cg.fs.WriteFile(cg.path+"/cgroup.threads", []byte(strconv.Itoa(tid)))
+}
+
+// Moves the calling OS thread into the group
+// Callers should runtime.LockOSThread() first, otherwise the goroutine may
+// move to another thread
+func (cg *group) AddCurrentThread(ctx context.Context) error {
+	return cg.AddThread(ctx, unix.Gettid())
+}
+
+// Valid values for CpusetConfig.Partition
+const (
+	PartitionMember   = "member"
+	PartitionRoot     = "root"
+	PartitionIsolated = "isolated"
+)
+
+// Configuration for the cpuset controller, empty fields are left unchanged
+type CpusetConfig struct {
+	CPUs      []int  // cpuset.cpus
+	Mems      []int  // cpuset.mems
+	Partition string // cpuset.cpus.partition
+}
+
+// Configures the cpuset controller of the group
+// CPUs and Mems are validated against the effective sets of the parent, and
+// if a partition is requested, the kernel's verdict is checked afterwards
+func (cg *group) SetCpuset(ctx context.Context, cfg CpusetConfig) error {
+	if cg.path == "" {
+		panic("cgroup not opened")
+	}
+
+	ctx = l.A("mcgPath", cg.path).Context(ctx)
+	parentPath := cg.path + "/.."
+
+	if len(cfg.CPUs) > 0 {
+		if err := cg.checkSubset(parentPath+"/cpuset.cpus.effective", cfg.CPUs); err != nil {
+			return err
+		}
+		if err := cg.fs.WriteFile(cg.path+"/cpuset.cpus", []byte(FormatCPUList(cfg.CPUs))); err != nil {
+			return err
+		}
+	}
+
+	if len(cfg.Mems) > 0 {
+		if err := cg.checkSubset(parentPath+"/cpuset.mems.effective", cfg.Mems); err != nil {
+			return err
+		}
+		if err := cg.fs.WriteFile(cg.path+"/cpuset.mems", []byte(FormatCPUList(cfg.Mems))); err != nil {
+			return err
+		}
+	}
+
+	if cfg.Partition != "" {
+		switch cfg.Partition {
+		case PartitionMember, PartitionRoot, PartitionIsolated:
+		default:
+			return fmt.Errorf("unknown cpuset partition type: %+q", cfg.Partition)
+		}
+		if err := cg.fs.WriteFile(cg.path+"/cpuset.cpus.partition", []byte(cfg.Partition)); err != nil {
+			return err
+		}
+		// Invalid partitions are accepted, but read back as "root invalid (reason)"
+		fb, err := cg.fs.ReadFile(cg.path + "/cpuset.cpus.partition")
+		if err != nil {
+			return err
+		}
+		if got := strings.TrimSpace(string(fb)); got != cfg.Partition {
+			return fmt.Errorf("cpuset partition not applied: %+q", got)
+		}
+	}
+
+	l.A("cpus", FormatCPUList(cfg.CPUs)).A("mems", FormatCPUList(cfg.Mems)).
+		A("partition", cfg.Partition).Debug(ctx, "mcg SetCpuset")
+	return nil
+}
+
+// CPUs that the group can actually use (cpuset.cpus.effective)
+func (cg *group) EffectiveCPUs(ctx context.Context) ([]int, error) {
+	if cg.path == "" {
+		panic("cgroup not opened")
+	}
+	return readCPUList(cg.fs, cg.path+"/cpuset.cpus.effective")
+}
+
+func (cg *group) checkSubset(effectivePath string, wanted []int) error {
+	effective, err := readCPUList(cg.fs, effectivePath)
+	if err != nil {
+		return err
+	}
+	missing := []int{}
+	for _, c := range wanted {
+		if !slices.Contains(effective, c) {
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("not in %+q (%s): %s",
+			effectivePath, FormatCPUList(effective), FormatCPUList(missing))
+	}
+	return nil
+}
+
+func readCPUList(fsys FS, path string) ([]int, error) {
+	fb, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCPUList(string(fb))
+}
+
+// The largest cpu or node number ParseCPUList accepts, the kernel's limit
+// (NR_CPUS with CONFIG_MAXSMP) is 8192 cpus
+const cpuListMax = 8191
+
+// Parses the kernel's list syntax, used for cpus and memory nodes: "0-3,8"
+// Ranges can have a stride, "0-15:2/4" is the first 2 of every 4: 0,1,4,5,8...
+// Returns a sorted list without duplicates
+func ParseCPUList(s string) ([]int, error) {
+	cpus := []int{}
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return cpus, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		invalid := func(why string) error {
+			return fmt.Errorf("invalid cpu list %+q: %+q %s", s, part, why)
+		}
+		bounds, stride, hasStride := strings.Cut(part, ":")
+		first, last, isRange := strings.Cut(bounds, "-")
+		start, err := strconv.Atoi(first)
+		if err != nil || start < 0 {
+			return nil, invalid("is not a number or range")
+		}
+		end := start
+		if isRange {
+			end, err = strconv.Atoi(last)
+			if err != nil || end < start {
+				return nil, invalid("is not a number or range")
+			}
+		}
+		if end > cpuListMax {
+			return nil, invalid(fmt.Sprintf("is above %d", cpuListMax))
+		}
+		used, group := 1, 1
+		if hasStride {
+			usedStr, groupStr, ok := strings.Cut(stride, "/")
+			used, err = strconv.Atoi(usedStr)
+			if !isRange || !ok || err != nil {
+				return nil, invalid("has an invalid stride, want start-end:used/group")
+			}
+			group, err = strconv.Atoi(groupStr)
+			if err != nil || used < 0 || group < 1 || used > group {
+				return nil, invalid("has an invalid stride, want start-end:used/group")
+			}
+		}
+		for g := start; g <= end; g += group {
+			for c := g; c < g+used && c <= end; c++ {
+				cpus = append(cpus, c)
+			}
+		}
+	}
+	slices.Sort(cpus)
+	return slices.Compact(cpus), nil
+}
+
+// Formats cpus using the kernel's list syntax, collapsing runs into ranges: "0-3,8"
+func FormatCPUList(cpus []int) string {
+	sorted := slices.Compact(slices.Sorted(slices.Values(cpus)))
+	parts := []string{}
+	for i := 0; i < len(sorted); {
+		j := i
+		for j+1 < len(sorted) && sorted[j+1] == sorted[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, strconv.Itoa(sorted[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,104 @@
+package minicgroups_test
+
+import (
+	"context"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/minicgroups"
+)
+
+func TestParseCPUList(t *testing.T) {
+	cpus, err := minicgroups.ParseCPUList("0-3,8\n")
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 1, 2, 3, 8}, cpus)
+
+	cpus, err = minicgroups.ParseCPUList("5,1-2,2")
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2, 5}, cpus)
+
+	cpus, err = minicgroups.ParseCPUList("")
+	require.NoError(t, err)
+	require.Equal(t, []int{}, cpus)
+
+	// Stride form, the first 2 of every 4
+	cpus, err = minicgroups.ParseCPUList("0-10:2/4,16")
+	require.NoError(t, err)
+	require.Equal(t, []int{0, 1, 4, 5, 8, 9, 16}, cpus)
+
+	cpus, err = minicgroups.ParseCPUList("0-8191")
+	require.NoError(t, err)
+	require.Len(t, cpus, 8192)
+
+	for _, bad := range []string{
+		"3-1", "a", "1,,2", "-1", "1-",
+		"0-2147483647", "8192", "0-99999999999999999999",
+		"0-15:2", "0-15:5/4", "0-15:1/0", "0-15:-1/4", "3:1/2", "0-15:a/4",
+	} {
+		_, err := minicgroups.ParseCPUList(bad)
+		require.Error(t, err, bad)
+	}
+
+	require.Equal(t, "0-3,8", minicgroups.FormatCPUList([]int{8, 3, 2, 1, 0}))
+	require.Equal(t, "1,3,5-6", minicgroups.FormatCPUList([]int{1, 3, 5, 6, 6}))
+	require.Equal(t, "", minicgroups.FormatCPUList(nil))
+}
+
+func TestFakeCpuset(t *testing.T) {
+	ctx := context.Background()
+
+	fsys := minicgroups.NewFakeFS("/fake/cgroup", []string{"cpuset", "cpu"})
+	parent, err := minicgroups.CreateFS(ctx, fsys, "/fake/cgroup/jobs", nil)
+	require.NoError(t, err)
+	g, err := minicgroups.CreateFS(ctx, fsys, "/fake/cgroup/jobs/rt", []string{"cpuset"})
+	require.NoError(t, err)
+	require.NoError(t, fsys.SetFile(parent.Path()+"/cpuset.cpus.effective", "0-7"))
+	require.NoError(t, fsys.SetFile(parent.Path()+"/cpuset.mems.effective", "0"))
+
+	err = g.SetCpuset(ctx, minicgroups.CpusetConfig{CPUs: []int{6, 7, 8, 9}})
+	require.ErrorContains(t, err, "(0-7): 8-9")
+
+	err = g.SetCpuset(ctx, minicgroups.CpusetConfig{Partition: "bogus"})
+	require.ErrorContains(t, err, "unknown cpuset partition type")
+
+	require.NoError(t, g.SetCpuset(ctx, minicgroups.CpusetConfig{
+		CPUs:      []int{4, 5, 6, 7},
+		Mems:      []int{0},
+		Partition: minicgroups.PartitionIsolated,
+	}))
+	fcs, err := g.ReadFiles(ctx, []string{"cpuset.cpus", "cpuset.mems", "cpuset.cpus.partition"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"4-7\n", "0\n", "isolated\n"}, fcs)
+
+}
+
+func TestFakeThreaded(t *testing.T) {
+	ctx := context.Background()
+
+	fsys := minicgroups.NewFakeFS("/fake/cgroup", []string{"cpu"})
+	domain, err := minicgroups.CreateFS(ctx, fsys, "/fake/cgroup/app", nil)
+	require.NoError(t, err)
+	g, err := minicgroups.CreateFS(ctx, fsys, "/fake/cgroup/app/latency", nil)
+	require.NoError(t, err)
+
+	typ, err := g.Type(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "domain", typ)
+
+	require.NoError(t, g.SetThreaded(ctx))
+	typ, err = g.Type(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "threaded", typ)
+
+	require.NoError(t, domain.WriteFiles(ctx, map[string]string{"cgroup.procs": "100"}))
+	require.NoError(t, g.AddThread(ctx, 101))
+	require.NoError(t, g.AddThread(ctx, 102))
+
+	tids, err := g.Threads(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []int{101, 102}, tids)
+
+	err = g.WriteFiles(ctx, map[string]string{"cgroup.procs": "100"})
+	require.ErrorIs(t, err, syscall.EOPNOTSUPP)
+}
@@ -0,0 +1,138 @@
+package minicgroups
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Lists the files that must be chowned when delegating a group
+var delegateListPath = "/sys/kernel/cgroup/delegate"
+
+// Used if the kernel doesn't have delegateListPath
+var defaultDelegateFiles = []string{
+	"cgroup.procs",
+	"cgroup.threads",
+	"cgroup.subtree_control",
+}
+
+// Starts cmd directly inside of the group (using clone3 CLONE_INTO_CGROUP),
+// so there is no window where the child runs in our group.
+// If cgroupNamespace is set, the child also gets a new cgroup namespace
+// rooted at this group, so it sees this group as "/"
+// NOTE: This always uses the real filesystem, even when the group was made with a fake FS
+func (cg *group) StartCmd(ctx context.Context, cmd *exec.Cmd, cgroupNamespace bool) error {
+	if cg.path == "" {
+		panic("cgroup not opened")
+	}
+
+	// CLONE_INTO_CGROUP doesn't accept O_PATH fds, so we can't use FD()
+	dir, err := os.OpenFile(cg.path, os.O_RDONLY|unix.O_DIRECTORY, 0)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(dir.Fd())
+	if cgroupNamespace {
+		cmd.SysProcAttr.Cloneflags |= unix.CLONE_NEWCGROUP
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	l.A("mcgPath", cg.path).A("pid", cmd.Process.Pid).
+		A("cgroupNamespace", cgroupNamespace).Debug(ctx, "mcg StartCmd")
+	return nil
+}
+
+// Delegates the group to uid/gid, following the kernel's delegation rules:
+// the directory and the files listed in /sys/kernel/cgroup/delegate
+// (cgroup.procs, cgroup.threads, cgroup.subtree_control...) are chowned.
+// Descendants that already exist are chowned entirely, like ones the
+// delegatee creates itself.
+// The delegatee can then create and manage nested groups, but can't change
+// the limits set on this group itself.
+// NOTE: To move processes in, the delegatee also needs write access to
+// cgroup.procs of the common ancestor, that isn't handled here
+func (cg *group) Delegate(ctx context.Context, uid, gid int) error {
+	if cg.path == "" {
+		panic("cgroup not opened")
+	}
+
+	files, err := delegateFiles(cg.fs)
+	if err != nil {
+		return err
+	}
+
+	if err := cg.fs.Chown(cg.path, uid, gid); err != nil {
+		return err
+	}
+	for _, name := range files {
+		err := cg.fs.Chown(cg.path+"/"+name, uid, gid)
+		// Some files only exist when the controller is enabled
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	for e, err := range cg.Walk(ctx) {
+		if err != nil {
+			return err
+		}
+		if err := chownGroup(e.Group, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	l.A("mcgPath", cg.path).A("uid", uid).A("gid", gid).Debug(ctx, "mcg Delegate")
+	return nil
+}
+
+// Chowns the group directory and all of its files
+func chownGroup(cg *group, uid, gid int) error {
+	entries, err := cg.fs.ReadDir(cg.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := cg.fs.Chown(cg.path, uid, gid); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		err := cg.fs.Chown(cg.path+"/"+e.Name(), uid, gid)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read through fsys, so fake filesystems get defaultDelegateFiles
+func delegateFiles(fsys FS) ([]string, error) {
+	fb, err := fsys.ReadFile(delegateListPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return defaultDelegateFiles, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading delegate list: %w", err)
+	}
+	return strings.Fields(string(fb)), nil
+}
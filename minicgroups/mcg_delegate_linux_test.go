@@ -0,0 +1,78 @@
+package minicgroups_test
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/minicgroups"
+	"gitlab.com/croepha/common-utils/syscallextra"
+)
+
+func TestFakeDelegate(t *testing.T) {
+	ctx := context.Background()
+
+	fsys := minicgroups.NewFakeFS("/fake/cgroup", []string{"memory"})
+	g, err := fsys.Mount().CreateGroup(ctx, nil)
+	require.NoError(t, err)
+
+	// Existing children are delegated along with the group
+	_, err = minicgroups.CreateFS(ctx, fsys, g.Path()+"/a/b", []string{"memory"})
+	require.NoError(t, err)
+
+	require.NoError(t, g.Delegate(ctx, 1000, 1001))
+
+	owner := func(name string) [2]uint32 {
+		fi, err := fsys.Stat(g.Path() + name)
+		require.NoError(t, err)
+		st := fi.Sys().(*syscall.Stat_t)
+		return [2]uint32{st.Uid, st.Gid}
+	}
+	require.Equal(t, [2]uint32{1000, 1001}, owner(""))
+	require.Equal(t, [2]uint32{1000, 1001}, owner("/cgroup.procs"))
+	require.Equal(t, [2]uint32{1000, 1001}, owner("/cgroup.subtree_control"))
+	require.Equal(t, [2]uint32{1000, 1001}, owner("/cgroup.threads"))
+
+	// Limits on the group itself stay with us
+	require.Equal(t, [2]uint32{0, 0}, owner("/cpu.stat"))
+	require.Equal(t, [2]uint32{0, 0}, owner("/cgroup.type"))
+	require.Equal(t, [2]uint32{0, 0}, owner("/memory.max"))
+
+	// But the delegatee sets the limits of the children
+	for _, name := range []string{"/a", "/a/memory.max", "/a/cgroup.type", "/a/b", "/a/b/memory.max", "/a/b/cgroup.procs"} {
+		require.Equal(t, [2]uint32{1000, 1001}, owner(name), name)
+	}
+}
+
+func TestStartCmdNamespace(t *testing.T) {
+	syscallextra.RequireNoFDLeaks(t)
+	ctx := context.Background()
+
+	cgm, err := minicgroups.NewMount(ctx)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, cgm.Done(ctx)) }()
+
+	g, err := cgm.CreateGroup(ctx, nil)
+	require.NoError(t, err)
+
+	run := func(cgroupNamespace bool) string {
+		out := strings.Builder{}
+		cmd := exec.Command("cat", "/proc/self/cgroup")
+		cmd.Stdout = &out
+		require.NoError(t, g.StartCmd(ctx, cmd, cgroupNamespace))
+		require.NoError(t, cmd.Wait())
+		for _, line := range strings.Split(out.String(), "\n") {
+			if p, ok := strings.CutPrefix(line, "0::"); ok {
+				return p
+			}
+		}
+		t.Fatalf("no cgroup v2 line in: %s", out.String())
+		return ""
+	}
+
+	require.True(t, strings.HasSuffix(g.Path(), run(false)))
+	require.Equal(t, "/", run(true))
+}
@@ -0,0 +1,126 @@
+package minicgroups
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gitlab.com/croepha/common-utils/syscallextra"
+)
+
+// Name of the leaf group that Discover moves this process into
+const selfLeafName = "mcg-self"
+
+// Uses the existing cgroup v2 mount instead of mounting a new one, this
+// doesn't need CAP_SYS_ADMIN, so it works inside of most containers as long
+// as our cgroup has been delegated to us.
+// Groups made with CreateGroup are created under the group this process
+// belongs to.  Because of the no internal process rule, this process is
+// first moved into a leaf group.  Done will not unmount anything
+func Discover(ctx context.Context) (*mount, error) {
+	return DiscoverFrom(ctx, OSFS, "/proc/self/mountinfo", "/proc/self/cgroup")
+}
+
+// Like Discover, but reads the given proc files instead of /proc/self, and
+// does cgroup file operations through fsys
+func DiscoverFrom(ctx context.Context, fsys FS, mountinfoPath, procCgroupPath string) (*mount, error) {
+	mntPath, selfPath, err := SelfPathFrom(mountinfoPath, procCgroupPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = l.A("mcgPath", selfPath).Context(ctx)
+
+	leaf, err := CreateFS(ctx, fsys, selfPath+"/"+selfLeafName, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := leaf.WriteFiles(ctx, map[string]string{
+		"cgroup.procs": strconv.Itoa(os.Getpid()),
+	}); err != nil {
+		return nil, fmt.Errorf("moving self to leaf group: %w", err)
+	}
+
+	l.Debug(ctx, "mcg Discover")
+	return &mount{mntPath: mntPath, basePath: selfPath, fs: fsys}, nil
+}
+
+// Finds the cgroup v2 group that this process belongs to
+// returns the mount point of the cgroup2 filesystem and the full path of the group
+func SelfPath() (mntPath string, groupPath string, err error) {
+	return SelfPathFrom("/proc/self/mountinfo", "/proc/self/cgroup")
+}
+
+// Like SelfPath, but reads the given files instead of /proc/self, useful for
+// testing with fixture files
+func SelfPathFrom(mountinfoPath, procCgroupPath string) (mntPath string, groupPath string, err error) {
+	cgPath, err := parseProcCgroup(procCgroupPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	mounts, err := syscallextra.ReadMountInfo(mountinfoPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	// If there are several cgroup2 mounts, prefer the one with the most specific root
+	bestRoot := ""
+	for _, m := range mounts {
+		if m.FSType != "cgroup2" {
+			continue
+		}
+		rel, ok := pathBeneath(cgPath, m.Root)
+		if !ok {
+			continue
+		}
+		if mntPath != "" && len(m.Root) <= len(bestRoot) {
+			continue
+		}
+		bestRoot = m.Root
+		mntPath = m.MountPoint
+		groupPath = strings.TrimSuffix(m.MountPoint+"/"+rel, "/")
+	}
+
+	if mntPath == "" {
+		return "", "", fmt.Errorf("no cgroup2 mount in %+q contains %+q", mountinfoPath, cgPath)
+	}
+	return mntPath, groupPath, nil
+}
+
+// returns path relative to root, if path is under root
+func pathBeneath(path, root string) (string, bool) {
+	if root == "/" {
+		return strings.TrimPrefix(path, "/"), true
+	}
+	if path == root {
+		return "", true
+	}
+	if rel, ok := strings.CutPrefix(path, root+"/"); ok {
+		return rel, true
+	}
+	return "", false
+}
+
+// Finds the cgroup v2 entry ("0::/path") in a /proc/<pid>/cgroup file
+func parseProcCgroup(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if cgPath, ok := strings.CutPrefix(s.Text(), "0::"); ok {
+			return cgPath, nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no cgroup v2 entry in %+q", path)
+}
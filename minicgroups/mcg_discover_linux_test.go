@@ -0,0 +1,45 @@
+package minicgroups_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/minicgroups"
+)
+
+func TestSelfPathFrom(t *testing.T) {
+	mntPath, groupPath, err := minicgroups.SelfPathFrom(
+		"testdata/mountinfo-hybrid", "testdata/cgroup-hybrid")
+	require.NoError(t, err)
+	require.Equal(t, "/sys/fs/cgroup/unified", mntPath)
+	require.Equal(t, "/sys/fs/cgroup/unified/user.slice/user-1000.slice/session-2.scope", groupPath)
+
+	// Mount root is a subgroup, and doesn't contain the /jobs mount
+	mntPath, groupPath, err = minicgroups.SelfPathFrom(
+		"testdata/mountinfo-container", "testdata/cgroup-container")
+	require.NoError(t, err)
+	require.Equal(t, "/sys/fs/cgroup", mntPath)
+	require.Equal(t, "/sys/fs/cgroup/worker", groupPath)
+
+	// Escaped mount point
+	_, groupPath, err = minicgroups.SelfPathFrom(
+		"testdata/mountinfo-container", "testdata/cgroup-jobs")
+	require.NoError(t, err)
+	require.Equal(t, "/mnt/job cgroups/a", groupPath)
+
+	_, _, err = minicgroups.SelfPathFrom(
+		"testdata/mountinfo-hybrid", "testdata/cgroup-v1only")
+	require.ErrorContains(t, err, "no cgroup v2 entry")
+
+	_, _, err = minicgroups.SelfPathFrom(
+		"testdata/mountinfo-container", "testdata/cgroup-hybrid")
+	require.ErrorContains(t, err, "no cgroup2 mount")
+}
+
+func TestSelfPath(t *testing.T) {
+	_, groupPath, err := minicgroups.SelfPath()
+	if err != nil {
+		t.Skipf("no cgroup v2: %s", err)
+	}
+	require.FileExists(t, groupPath+"/cgroup.procs")
+}
@@ -0,0 +1,599 @@
+package minicgroups
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gitlab.com/croepha/common-utils/lostandfound"
+)
+
+/*
+
+An in-memory emulation of a cgroup2 filesystem, for unit testing without root
+
+Emulated:
+ - cgroup.controllers follows the parent's cgroup.subtree_control
+ - cgroup.subtree_control checks availability, the no internal process rule
+   and refuses to disable controllers that children still use
+ - Interface files (memory.max, cpu.max...) appear and disappear as
+   controllers are enabled on the parent
+ - cgroup.procs moves pids between groups, cgroup.events reports populated
+ - cgroup.kill empties the subtree immediately, or is missing after
+   DisableKillFile, then the Kill fallback signals through KillPid
+ - cgroup.freeze is stored and reported as frozen by cgroup.events
+ - cgroup.type can be switched to threaded, threaded groups take tids
+   through cgroup.threads and refuse cgroup.procs
+ - Directories can't be removed while they have children or processes
+ - Ownership from Chown is reported by Stat (Sys() is a *syscall.Stat_t)
+
+Everything else is just stored and echoed back.  No real processes are
+affected, pids are only tracked as numbers
+
+*/
+
+// Default contents of the interface files of each controller
+var fakeControllerFiles = map[string]map[string]string{
+	"cpuset": {
+		"cpuset.cpus":           "",
+		"cpuset.mems":           "",
+		"cpuset.cpus.effective": "",
+		"cpuset.mems.effective": "",
+		"cpuset.cpus.partition": "member",
+	},
+	"cpu": {
+		"cpu.max":    "max 100000",
+		"cpu.weight": "100",
+	},
+	"io": {
+		"io.max":    "",
+		"io.weight": "default 100",
+		"io.stat":   "",
+	},
+	"memory": {
+		"memory.max":     "max",
+		"memory.high":    "max",
+		"memory.low":     "0",
+		"memory.current": "0",
+		"memory.peak":    "0",
+		"memory.events":  "low 0\nhigh 0\nmax 0\noom 0\noom_kill 0",
+		"memory.stat":    "anon 0\nfile 0",
+	},
+	"pids": {
+		"pids.max":     "max",
+		"pids.current": "0",
+	},
+}
+
+// Files present in every non-root group, regardless of controllers
+var fakeCoreFiles = map[string]string{
+	"cpu.stat": "usage_usec 0\nuser_usec 0\nsystem_usec 0",
+}
+
+// Files generated from the state of the group
+var fakeSpecialFiles = []string{
+	"cgroup.controllers",
+	"cgroup.events",
+	"cgroup.freeze",
+	"cgroup.kill",
+	"cgroup.procs",
+	"cgroup.subtree_control",
+	"cgroup.threads",
+	"cgroup.type",
+}
+
+func fakeReadOnly(name string) bool {
+	switch name {
+	case "cgroup.controllers", "cgroup.events", "cpu.stat":
+		return true
+	}
+	for _, suffix := range []string{".current", ".effective", ".events", ".peak", ".stat"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+type fakeGroup struct {
+	name           string
+	parent         *fakeGroup // nil for the root
+	children       map[string]*fakeGroup
+	subtreeControl []string
+	procs          []int
+	threads        []int // Only for threaded groups, tids moved in individually
+	cgType         string
+	frozen         bool
+	files          map[string]string
+	owners         map[string]fakeOwner // "" is the directory itself
+}
+
+type fakeOwner struct {
+	uid, gid int
+}
+
+type fakeFS struct {
+	mut             sync.Mutex
+	rootPath        string
+	rootControllers []string
+	root            *fakeGroup
+	noKill          bool // No cgroup.kill, like kernels before 5.14
+	onKillPid       func(pid int)
+}
+
+// Creates an in-memory fake cgroup2 filesystem, rooted at rootPath
+// (nothing is created on disk at rootPath), controllers are the ones
+// available at the root
+func NewFakeFS(rootPath string, controllers []string) *fakeFS {
+	return &fakeFS{
+		rootPath:        filepath.Clean(rootPath),
+		rootControllers: controllers,
+		root: &fakeGroup{
+			children: map[string]*fakeGroup{},
+			files:    map[string]string{},
+			owners:   map[string]fakeOwner{},
+		},
+	}
+}
+
+// Returns a mount for the root of the fake filesystem, Done is a NOP
+func (f *fakeFS) Mount() *mount {
+	return &mount{mntPath: f.rootPath, basePath: f.rootPath, fs: f}
+}
+
+// Emulates a kernel older than 5.14, which has no cgroup.kill
+func (f *fakeFS) DisableKillFile() {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.noKill = true
+}
+
+// Emulates the pid exiting from SIGKILL, it is removed from its group
+func (f *fakeFS) KillPid(pid int) error {
+	f.mut.Lock()
+	f.removePid(f.root, pid)
+	f.removeTid(f.root, pid)
+	onKillPid := f.onKillPid
+	f.mut.Unlock()
+	if onKillPid != nil {
+		onKillPid(pid)
+	}
+	return nil
+}
+
+// Calls fn after each KillPid, lets tests emulate processes that fork while
+// they are being killed
+func (f *fakeFS) OnKillPid(fn func(pid int)) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	f.onKillPid = fn
+}
+
+// Overwrites the contents of an interface file, even read-only ones
+// Lets tests fake things like memory.peak or cpu.stat
+func (f *fakeFS) SetFile(path string, content string) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	g, name, err := f.lookupFile("setfile", path)
+	if err != nil {
+		return err
+	}
+	if slices.Contains(fakeSpecialFiles, name) {
+		return &fs.PathError{Op: "setfile", Path: path, Err: syscall.EINVAL}
+	}
+	g.files[name] = content
+	return nil
+}
+
+func (g *fakeGroup) isRoot() bool {
+	return g.parent == nil
+}
+
+func (f *fakeFS) controllers(g *fakeGroup) []string {
+	if g.isRoot() {
+		return f.rootControllers
+	}
+	return g.parent.subtreeControl
+}
+
+func (g *fakeGroup) populated() bool {
+	if len(g.procs) > 0 || len(g.threads) > 0 {
+		return true
+	}
+	for _, c := range g.children {
+		if c.populated() {
+			return true
+		}
+	}
+	return false
+}
+
+// Freezing applies to the whole subtree
+func (g *fakeGroup) isFrozen() bool {
+	for ; g != nil; g = g.parent {
+		if g.frozen {
+			return true
+		}
+	}
+	return false
+}
+
+func fakeBool(b bool) string {
+	if b {
+		return "1"
+	}
+	return "0"
+}
+
+func (f *fakeFS) fileNames(g *fakeGroup) []string {
+	names := []string{}
+	for _, name := range fakeSpecialFiles {
+		if g.isRoot() && (name == "cgroup.type" || name == "cgroup.events" || name == "cgroup.freeze" || name == "cgroup.kill") {
+			continue
+		}
+		if f.noKill && name == "cgroup.kill" {
+			continue
+		}
+		names = append(names, name)
+	}
+	for name := range g.files {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Adds and removes interface files to match the enabled controllers
+func (f *fakeFS) syncFiles(g *fakeGroup) {
+	enabled := f.controllers(g)
+	for controller, files := range fakeControllerFiles {
+		for name, content := range files {
+			_, exists := g.files[name]
+			if want := slices.Contains(enabled, controller); want && !exists {
+				g.files[name] = content
+			} else if !want && exists {
+				delete(g.files, name)
+			}
+		}
+	}
+}
+
+// Splits path into group components, fails if path isn't under the root
+func (f *fakeFS) components(op, path string) ([]string, error) {
+	path = filepath.Clean(path)
+	if path == f.rootPath {
+		return nil, nil
+	}
+	rel, ok := strings.CutPrefix(path, f.rootPath+"/")
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: path, Err: syscall.ENOENT}
+	}
+	return strings.Split(rel, "/"), nil
+}
+
+func (f *fakeFS) lookupGroup(op, path string) (*fakeGroup, error) {
+	parts, err := f.components(op, path)
+	if err != nil {
+		return nil, err
+	}
+	g := f.root
+	for _, p := range parts {
+		c, ok := g.children[p]
+		if !ok {
+			return nil, &fs.PathError{Op: op, Path: path, Err: syscall.ENOENT}
+		}
+		g = c
+	}
+	return g, nil
+}
+
+// Finds the group that contains the interface file at path
+func (f *fakeFS) lookupFile(op, path string) (*fakeGroup, string, error) {
+	path = filepath.Clean(path)
+	g, err := f.lookupGroup(op, filepath.Dir(path))
+	if err != nil {
+		return nil, "", err
+	}
+	name := filepath.Base(path)
+	if !slices.Contains(f.fileNames(g), name) {
+		return nil, "", &fs.PathError{Op: op, Path: path, Err: syscall.ENOENT}
+	}
+	return g, name, nil
+}
+
+func (f *fakeFS) MkdirAll(path string) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	parts, err := f.components("mkdir", path)
+	if err != nil {
+		return err
+	}
+	g := f.root
+	for _, p := range parts {
+		if slices.Contains(f.fileNames(g), p) {
+			return &fs.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
+		}
+		c, ok := g.children[p]
+		if !ok {
+			c = &fakeGroup{
+				name:     p,
+				parent:   g,
+				children: map[string]*fakeGroup{},
+				cgType:   "domain",
+				files:    map[string]string{},
+				owners:   map[string]fakeOwner{},
+			}
+			for name, content := range fakeCoreFiles {
+				c.files[name] = content
+			}
+			f.syncFiles(c)
+			g.children[p] = c
+		}
+		g = c
+	}
+	return nil
+}
+
+func (f *fakeFS) Remove(path string) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	g, err := f.lookupGroup("remove", path)
+	if err != nil {
+		if _, _, ferr := f.lookupFile("remove", path); ferr == nil {
+			return &fs.PathError{Op: "remove", Path: path, Err: syscall.EPERM}
+		}
+		return err
+	}
+	if g.isRoot() || len(g.children) > 0 || g.populated() {
+		return &fs.PathError{Op: "remove", Path: path, Err: syscall.EBUSY}
+	}
+	delete(g.parent.children, g.name)
+	return nil
+}
+
+func (f *fakeFS) ReadFile(path string) ([]byte, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	g, name, err := f.lookupFile("open", path)
+	if err != nil {
+		if _, gerr := f.lookupGroup("read", path); gerr == nil {
+			return nil, &fs.PathError{Op: "read", Path: path, Err: syscall.EISDIR}
+		}
+		return nil, err
+	}
+
+	content := ""
+	switch name {
+	case "cgroup.controllers":
+		content = strings.Join(f.controllers(g), " ")
+	case "cgroup.subtree_control":
+		content = strings.Join(g.subtreeControl, " ")
+	case "cgroup.procs":
+		content = strings.Join(lostandfound.MapApply(g.procs, strconv.Itoa), "\n")
+	case "cgroup.threads":
+		content = strings.Join(lostandfound.MapApply(slices.Concat(g.procs, g.threads), strconv.Itoa), "\n")
+	case "cgroup.events":
+		populated := 0
+		if g.populated() {
+			populated = 1
+		}
+		content = "populated " + strconv.Itoa(populated) + "\nfrozen " + fakeBool(g.isFrozen())
+	case "cgroup.freeze":
+		content = fakeBool(g.frozen)
+	case "cgroup.type":
+		content = g.cgType
+	case "cgroup.kill":
+		return nil, &fs.PathError{Op: "read", Path: path, Err: syscall.EINVAL}
+	default:
+		content = g.files[name]
+	}
+	if content == "" {
+		return []byte{}, nil
+	}
+	return []byte(content + "\n"), nil
+}
+
+func (f *fakeFS) WriteFile(path string, data []byte) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	g, name, err := f.lookupFile("open", path)
+	if err != nil {
+		return err
+	}
+	if fakeReadOnly(name) {
+		return &fs.PathError{Op: "open", Path: path, Err: syscall.EACCES}
+	}
+	werr := func(errno syscall.Errno) error {
+		return &fs.PathError{Op: "write", Path: path, Err: errno}
+	}
+	content := strings.TrimSpace(string(data))
+
+	switch name {
+	case "cgroup.subtree_control":
+		return f.writeSubtreeControl(g, content, werr)
+	case "cgroup.procs", "cgroup.threads":
+		pid, err := strconv.Atoi(content)
+		if err != nil || pid < 0 {
+			return werr(syscall.EINVAL)
+		}
+		if pid == 0 {
+			pid = os.Getpid()
+		}
+		if g.cgType == "threaded" {
+			if name == "cgroup.procs" {
+				return werr(syscall.EOPNOTSUPP)
+			}
+			f.removeTid(f.root, pid)
+			g.threads = append(g.threads, pid)
+			return nil
+		}
+		if !g.isRoot() && len(g.subtreeControl) > 0 {
+			return werr(syscall.EBUSY)
+		}
+		f.removePid(f.root, pid)
+		g.procs = append(g.procs, pid)
+		return nil
+	case "cgroup.kill":
+		if content != "1" {
+			return werr(syscall.EINVAL)
+		}
+		killAll(g)
+		return nil
+	case "cgroup.freeze":
+		if content != "0" && content != "1" {
+			return werr(syscall.EINVAL)
+		}
+		g.frozen = content == "1"
+		return nil
+	case "cgroup.type":
+		if content != "threaded" {
+			return werr(syscall.EINVAL)
+		}
+		g.cgType = content
+		return nil
+	default:
+		g.files[name] = content
+		return nil
+	}
+}
+
+func (f *fakeFS) writeSubtreeControl(g *fakeGroup, content string, werr func(syscall.Errno) error) error {
+	enable := []string{}
+	disable := []string{}
+	for _, tok := range strings.Fields(content) {
+		name := tok[1:]
+		switch tok[0] {
+		case '+':
+			if !slices.Contains(f.controllers(g), name) {
+				return werr(syscall.ENOENT)
+			}
+			enable = append(enable, name)
+		case '-':
+			for _, c := range g.children {
+				if slices.Contains(c.subtreeControl, name) {
+					return werr(syscall.EBUSY)
+				}
+			}
+			disable = append(disable, name)
+		default:
+			return werr(syscall.EINVAL)
+		}
+	}
+
+	if len(enable) > 0 && !g.isRoot() && len(g.procs) > 0 {
+		// No internal process rule
+		return werr(syscall.EBUSY)
+	}
+
+	next := []string{}
+	for _, c := range f.controllers(g) {
+		if slices.Contains(enable, c) ||
+			(slices.Contains(g.subtreeControl, c) && !slices.Contains(disable, c)) {
+			next = append(next, c)
+		}
+	}
+	g.subtreeControl = next
+	for _, c := range g.children {
+		f.syncFiles(c)
+	}
+	return nil
+}
+
+func (f *fakeFS) removePid(g *fakeGroup, pid int) {
+	g.procs = slices.DeleteFunc(g.procs, func(p int) bool { return p == pid })
+	for _, c := range g.children {
+		f.removePid(c, pid)
+	}
+}
+
+func (f *fakeFS) removeTid(g *fakeGroup, tid int) {
+	g.threads = slices.DeleteFunc(g.threads, func(t int) bool { return t == tid })
+	for _, c := range g.children {
+		f.removeTid(c, tid)
+	}
+}
+
+func killAll(g *fakeGroup) {
+	g.procs = nil
+	g.threads = nil
+	for _, c := range g.children {
+		killAll(c)
+	}
+}
+
+func (f *fakeFS) ReadDir(path string) ([]fs.DirEntry, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	g, err := f.lookupGroup("open", path)
+	if err != nil {
+		return nil, err
+	}
+	entries := []fs.DirEntry{}
+	for name, c := range g.children {
+		entries = append(entries, fakeInfo{name: name, dir: true, owner: c.owners[""]})
+	}
+	for _, name := range f.fileNames(g) {
+		entries = append(entries, fakeInfo{name: name, owner: g.owners[name]})
+	}
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, nil
+}
+
+func (f *fakeFS) Stat(path string) (fs.FileInfo, error) {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	if g, err := f.lookupGroup("stat", path); err == nil {
+		return fakeInfo{name: g.name, dir: true, owner: g.owners[""]}, nil
+	}
+	g, name, err := f.lookupFile("stat", path)
+	if err != nil {
+		return nil, err
+	}
+	return fakeInfo{name: name, owner: g.owners[name]}, nil
+}
+
+func (f *fakeFS) Chown(path string, uid, gid int) error {
+	f.mut.Lock()
+	defer f.mut.Unlock()
+	if g, err := f.lookupGroup("chown", path); err == nil {
+		g.owners[""] = fakeOwner{uid: uid, gid: gid}
+		return nil
+	}
+	g, name, err := f.lookupFile("chown", path)
+	if err != nil {
+		return err
+	}
+	g.owners[name] = fakeOwner{uid: uid, gid: gid}
+	return nil
+}
+
+// Implements fs.FileInfo and fs.DirEntry
+type fakeInfo struct {
+	name  string
+	dir   bool
+	owner fakeOwner
+}
+
+func (i fakeInfo) Name() string               { return i.name }
+func (i fakeInfo) IsDir() bool                { return i.dir }
+func (i fakeInfo) Type() fs.FileMode          { return i.Mode().Type() }
+func (i fakeInfo) Info() (fs.FileInfo, error) { return i, nil }
+func (i fakeInfo) Size() int64                { return 0 }
+func (i fakeInfo) ModTime() time.Time         { return time.Time{} }
+func (i fakeInfo) Sys() any {
+	return &syscall.Stat_t{Uid: uint32(i.owner.uid), Gid: uint32(i.owner.gid)}
+}
+func (i fakeInfo) Mode() fs.FileMode {
+	if i.dir {
+		return fs.ModeDir | 0755
+	}
+	return 0644
+}
@@ -0,0 +1,178 @@
+package minicgroups_test
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/minicgroups"
+)
+
+func TestFakeSuccess(t *testing.T) {
+	ctx := context.Background()
+
+	fsys := minicgroups.NewFakeFS("/fake/cgroup", []string{"cpu", "memory", "pids"})
+	cgm := fsys.Mount()
+	defer func() { require.NoError(t, cgm.Done(ctx)) }()
+
+	g, err := cgm.CreateGroup(ctx, []string{"memory", "cpu"})
+	require.NoError(t, err)
+
+	err = g.WriteFiles(ctx, map[string]string{
+		"memory.max": "134217728",
+		"cpu.max":    "1000 100000",
+	})
+	require.NoError(t, err)
+
+	fcs, err := g.ReadFiles(ctx, []string{
+		"memory.max",
+		"cpu.max",
+		"cgroup.controllers",
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"134217728\n", "1000 100000\n", "cpu memory\n"}, fcs)
+
+	// pids wasn't enabled, so there are no pids interface files
+	err = g.WriteFiles(ctx, map[string]string{"pids.max": "10"})
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// Read only
+	err = g.WriteFiles(ctx, map[string]string{"memory.current": "10"})
+	require.ErrorIs(t, err, os.ErrPermission)
+
+	require.NoError(t, g.Delete(ctx))
+}
+
+func TestFakeSubtreeControl(t *testing.T) {
+	ctx := context.Background()
+
+	fsys := minicgroups.NewFakeFS("/fake/cgroup", []string{"cpu", "memory"})
+
+	g, err := minicgroups.CreateFS(ctx, fsys, "/fake/cgroup/a/b", []string{"io"})
+	require.ErrorContains(t, err, "needed controller not present on root")
+	require.Nil(t, g)
+	// The group that couldn't be set up was removed again
+	_, err = minicgroups.OpenFS(ctx, fsys, "/fake/cgroup/a/b")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// Enabling on a/b/c propagates up to the root
+	c, err := minicgroups.CreateFS(ctx, fsys, "/fake/cgroup/a/b/c", []string{"memory"})
+	require.NoError(t, err)
+	a, err := minicgroups.OpenFS(ctx, fsys, "/fake/cgroup/a")
+	require.NoError(t, err)
+	fcs, err := a.ReadFiles(ctx, []string{"cgroup.controllers", "cgroup.subtree_control", "memory.max"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"memory\n", "memory\n", "max\n"}, fcs)
+
+	// Can't disable while a child is still using it
+	err = a.WriteFiles(ctx, map[string]string{"cgroup.subtree_control": "-memory"})
+	require.ErrorIs(t, err, syscall.EBUSY)
+
+	// No internal processes
+	b, err := minicgroups.OpenFS(ctx, fsys, "/fake/cgroup/a/b")
+	require.NoError(t, err)
+	err = b.WriteFiles(ctx, map[string]string{"cgroup.procs": "1234"})
+	require.ErrorIs(t, err, syscall.EBUSY)
+	require.NoError(t, c.WriteFiles(ctx, map[string]string{"cgroup.procs": "1234"}))
+	err = c.WriteFiles(ctx, map[string]string{"cgroup.subtree_control": "+memory"})
+	require.ErrorIs(t, err, syscall.EBUSY)
+
+	// Processes and children prevent removal
+	require.ErrorIs(t, c.Delete(ctx), syscall.EBUSY)
+	require.ErrorIs(t, b.Delete(ctx), syscall.EBUSY)
+
+	require.NoError(t, a.DeleteRecursive(ctx))
+	_, err = minicgroups.OpenFS(ctx, fsys, "/fake/cgroup/a")
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestFakeDiscover(t *testing.T) {
+	ctx := context.Background()
+
+	fsys := minicgroups.NewFakeFS("/sys/fs/cgroup/unified", []string{"memory"})
+	selfPath := "/sys/fs/cgroup/unified/user.slice/user-1000.slice/session-2.scope"
+	require.NoError(t, fsys.MkdirAll(selfPath))
+
+	cgm, err := minicgroups.DiscoverFrom(ctx, fsys,
+		"testdata/mountinfo-hybrid", "testdata/cgroup-hybrid")
+	require.NoError(t, err)
+	defer func() { require.NoError(t, cgm.Done(ctx)) }()
+
+	self, err := minicgroups.OpenFS(ctx, fsys, selfPath+"/mcg-self")
+	require.NoError(t, err)
+	procs, err := self.Procs(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []int{os.Getpid()}, procs)
+
+	// Now that we are out of the way, the sibling can have controllers
+	g, err := cgm.CreateGroup(ctx, []string{"memory"})
+	require.NoError(t, err)
+	require.Equal(t, selfPath+"/mcg-0", g.Path())
+}
+
+// Kernels before 5.14 have no cgroup.kill, so Kill signals each pid
+// Without cgroup.kill, pids that appear while the group is being killed
+// (forks that raced with the signal) are killed too
+func TestFakeKillWithoutKillFile(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	fsys := minicgroups.NewFakeFS("/fake/cgroup", nil)
+	fsys.DisableKillFile()
+	a, err := minicgroups.CreateFS(ctx, fsys, "/fake/cgroup/a", nil)
+	require.NoError(t, err)
+	b, err := minicgroups.CreateFS(ctx, fsys, "/fake/cgroup/a/b", nil)
+	require.NoError(t, err)
+	_, err = b.ReadFiles(ctx, []string{"cgroup.kill"})
+	require.ErrorIs(t, err, os.ErrNotExist)
+	require.NoError(t, b.WriteFiles(ctx, map[string]string{"cgroup.procs": "100"}))
+
+	// Every killed pid below 103 forked first, alternating between a and b
+	killed := []int{}
+	fsys.OnKillPid(func(pid int) {
+		killed = append(killed, pid)
+		events, err := a.ReadFiles(ctx, []string{"cgroup.events"})
+		require.NoError(t, err)
+		require.Contains(t, events[0], "frozen 1")
+		if pid < 103 {
+			child := b
+			if pid%2 == 0 {
+				child = a
+			}
+			require.NoError(t, child.WriteFiles(ctx, map[string]string{"cgroup.procs": strconv.Itoa(pid + 1)}))
+		}
+	})
+
+	require.NoError(t, a.Kill(ctx))
+	require.Equal(t, []int{100, 101, 102, 103}, killed)
+	files, err := a.ReadFiles(ctx, []string{"cgroup.events", "cgroup.freeze"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"populated 0\nfrozen 0\n", "0\n"}, files)
+
+	// A group that never empties gives up with the context
+	fsys.OnKillPid(func(pid int) {
+		require.NoError(t, b.WriteFiles(ctx, map[string]string{"cgroup.procs": strconv.Itoa(pid + 1)}))
+	})
+	require.NoError(t, b.WriteFiles(ctx, map[string]string{"cgroup.procs": "200"}))
+	shortCtx, shortCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer shortCancel()
+	require.ErrorIs(t, a.Kill(shortCtx), context.DeadlineExceeded)
+}
+
+func TestOSFSWriteFileDoesNotCreate(t *testing.T) {
+	path := t.TempDir() + "/cgroup.kill"
+	err := minicgroups.OSFS.WriteFile(path, []byte("1"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	require.NoError(t, os.WriteFile(path, []byte("old contents"), 0600))
+	require.NoError(t, minicgroups.OSFS.WriteFile(path, []byte("1")))
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "1", string(b))
+}
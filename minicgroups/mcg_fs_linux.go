@@ -0,0 +1,66 @@
+package minicgroups
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// The file operations that this package does on cgroupfs
+// Going through this allows code built on this package to be tested with
+// NewFakeFS, which doesn't need root or a real cgroup2 mount
+type FS interface {
+	MkdirAll(path string) error
+	Remove(path string) error
+	ReadFile(path string) ([]byte, error)
+	WriteFile(path string, data []byte) error
+	ReadDir(path string) ([]fs.DirEntry, error)
+	Stat(path string) (fs.FileInfo, error)
+	Chown(path string, uid, gid int) error
+}
+
+// Optionally implemented by an FS whose pids aren't real processes, the Kill
+// fallback for kernels without cgroup.kill signals through it
+type pidKiller interface {
+	KillPid(pid int) error
+}
+
+func killPid(fsys FS, pid int) error {
+	if k, ok := fsys.(pidKiller); ok {
+		return k.KillPid(pid)
+	}
+	err := syscall.Kill(pid, syscall.SIGKILL)
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
+
+// FS that uses the real filesystem
+var OSFS FS = osFS{}
+
+type osFS struct{}
+
+// cgroupfs ignores the permission bits, so we always pass 0
+
+func (osFS) MkdirAll(path string) error                 { return os.MkdirAll(path, 0) }
+func (osFS) Remove(path string) error                   { return os.Remove(path) }
+func (osFS) ReadFile(path string) ([]byte, error)       { return os.ReadFile(path) }
+func (osFS) ReadDir(path string) ([]fs.DirEntry, error) { return os.ReadDir(path) }
+func (osFS) Stat(path string) (fs.FileInfo, error)      { return os.Stat(path) }
+func (osFS) Chown(path string, uid, gid int) error      { return os.Chown(path, uid, gid) }
+
+// Unlike os.WriteFile this never creates the file, so a missing interface
+// file is ENOENT (kernfs reports EACCES for O_CREAT on a missing file)
+func (osFS) WriteFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"iter"
 	"os"
 	"strings"
@@ -18,11 +19,12 @@ import (
 
 var l loggingsugar.L
 
-// TODO: Cgroup namespace?
-
 type mount struct {
 	mntPath  string
 	rootPath string
+	basePath string // Where CreateGroup puts new groups
+	private  bool   // We mounted it, so Done should unmount it
+	fs       FS
 	nextId   atomic.Uint64
 }
 
@@ -33,18 +35,21 @@ func NewMount(ctx context.Context) (*mount, error) {
 		return nil, err
 	}
 
-	m := &mount{mntPath: mntPath}
+	m := &mount{mntPath: mntPath, basePath: mntPath, private: true, fs: OSFS}
 
-	if err := syscallextra.WrapEINTR(func() error {
-		return syscall.Mount("pvt-cgroup", mntPath, "cgroup2", 0, "")
-	}); err != nil {
+	if err := syscallextra.Mount("pvt-cgroup", mntPath, "cgroup2", 0, ""); err != nil {
+		if errors.Is(err, syscall.EPERM) {
+			if capErr := syscallextra.RequireSysAdmin(); capErr != nil {
+				err = fmt.Errorf("%w: %w", err, capErr)
+			}
+		}
 		l.Err(err).Error(ctx, "error")
 		return nil, err
 	}
 
 	rootAttempts := 5
 	for range rootAttempts {
-		rootPath := fmt.Sprintf("%s/mcgroot-%d", mntPath, time.Now().Unix())
+		rootPath := fmt.Sprintf("%s/mcgroot-%d", mntPath, time.Now().UnixNano())
 		m.rootPath = rootPath
 		err := os.Mkdir(rootPath, 0)
 		if err == nil {
@@ -61,16 +66,29 @@ func NewMount(ctx context.Context) (*mount, error) {
 
 rootCreated:
 
-	return &mount{mntPath: mntPath}, nil
+	// The cgroup2 hierarchy is shared with every other mount of it, so keep
+	// our groups under our own root
+	m.basePath = m.rootPath
+	return m, nil
 }
 
+// Releases the mount, for mounts made by NewMount this also kills every
+// process left in our groups and removes them, before unmounting
 func (m *mount) Done(ctx context.Context) error {
 	if m.mntPath == "" {
 		panic("mount not opened")
 	}
-	if err := syscallextra.WrapEINTR(func() error {
-		return syscall.Unmount(m.mntPath, 0)
-	}); err != nil {
+	if !m.private {
+		m.mntPath = ""
+		return nil
+	}
+	if m.rootPath != "" {
+		root := &group{path: m.rootPath, fs: m.fs}
+		if err := root.DeleteRecursive(ctx); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if err := syscallextra.Unmount(m.mntPath, 0); err != nil {
 		return err
 	}
 	if err := os.Remove(m.mntPath); err != nil {
@@ -87,38 +105,45 @@ func (m *mount) CreateGroup(ctx context.Context, controllers []string) (*group,
 	}
 
 try:
-	path := fmt.Sprintf("%s/mcg-%d", m.mntPath, m.nextId.Add(1)-1)
-	group, err := Create(ctx, path, controllers)
+	path := fmt.Sprintf("%s/mcg-%d", m.basePath, m.nextId.Add(1)-1)
+	group, err := CreateFS(ctx, m.fs, path, controllers)
 
 	if errors.Is(err, syscall.EEXIST) {
 		goto try
 	}
 
-	return group, nil
+	return group, err
 }
 
 type group struct {
 	path string
+	fs   FS
 }
 
 // Create (if it doesn't exist) a v2 cgroup at path
 // path must be under a cgroup v2 mount
 // Also ensure that the given controllers are enabled
 func Create(ctx context.Context, path string, controllers []string) (*group, error) {
+	return CreateFS(ctx, OSFS, path, controllers)
+}
+
+// Like Create, but does all file operations through fsys
+func CreateFS(ctx context.Context, fsys FS, path string, controllers []string) (*group, error) {
 
 	ctx = l.A("mcgPath", path).Context(ctx)
 
-	if err := os.MkdirAll(path, 0); err != nil {
+	if err := fsys.MkdirAll(path); err != nil {
 		return nil, err
 	}
 
-	cg := &group{path: path}
+	cg := &group{path: path, fs: fsys}
 	if err := cg.EnableControllers(ctx, controllers); err != nil {
 		if err2 := cg.Delete(ctx); err2 != nil {
 			return cg, fmt.Errorf(
 				"second error: %w while cleaning up from original error: %w",
 				err2, err)
 		}
+		return nil, err
 	}
 
 	l.Debug(ctx, "mcg Create")
@@ -129,7 +154,7 @@ func (cg *group) EnableControllers(ctx context.Context, controllers []string) er
 	if cg.path == "" {
 		panic("cgroup not opened")
 	}
-	return enableControllers(ctx, cg.path, controllers)
+	return enableControllers(ctx, cg.fs, cg.path, controllers)
 }
 
 func (cg *group) WriteFiles(ctx context.Context, fileContents map[string]string) error {
@@ -138,7 +163,7 @@ func (cg *group) WriteFiles(ctx context.Context, fileContents map[string]string)
 	}
 	for name, content := range fileContents {
 		path := cg.path + "/" + name
-		if err := os.WriteFile(path, []byte(content), 0); err != nil {
+		if err := cg.fs.WriteFile(path, []byte(content)); err != nil {
 			return err
 		}
 	}
@@ -153,7 +178,7 @@ func (cg *group) ReadFiles(ctx context.Context, files []string) ([]string, error
 	for _, name := range files {
 		path := cg.path + "/" + name
 
-		if fb, err := os.ReadFile(path); err != nil {
+		if fb, err := cg.fs.ReadFile(path); err != nil {
 			return nil, err
 		} else {
 			contents = append(contents, string(fb))
@@ -162,6 +187,8 @@ func (cg *group) ReadFiles(ctx context.Context, files []string) ([]string, error
 	return contents, nil
 }
 
+// Opens an O_PATH fd to the group directory
+// NOTE: This always uses the real filesystem, even when the group was made with a fake FS
 func (cg *group) FD(ctx context.Context) iter.Seq2[int, error] {
 	if cg.path == "" {
 		panic("cgroup not opened")
@@ -173,7 +200,7 @@ func (cg *group) Delete(ctx context.Context) error {
 	if cg.path == "" {
 		panic("cgroup not opened")
 	}
-	err := os.Remove(cg.path)
+	err := cg.fs.Remove(cg.path)
 	if err != nil {
 		return err
 	}
@@ -184,31 +211,31 @@ func (cg *group) Delete(ctx context.Context) error {
 // TODO: Mutex? so that global cgroup operations are serialized?
 // TODO: Inotify to listen for changes?
 
-func enableControllers(ctx context.Context, path string, needed []string) error {
+func enableControllers(ctx context.Context, fsys FS, path string, needed []string) error {
 
 	if len(needed) == 0 {
 		return nil
 	}
 
-	fb, err := os.ReadFile(path + "/cgroup.controllers")
+	fb, err := fsys.ReadFile(path + "/cgroup.controllers")
 	if err != nil {
 		return err
 	}
 
-	current := strings.Split(string(fb), " ")
+	current := strings.Fields(string(fb))
 	missing := lostandfound.SliceSubtract(needed, current)
 
 	if len(missing) == 0 {
 		return nil
 	}
 
-	if !lostandfound.FileExists(path + "/cgroup.type") {
+	if _, err := fsys.Stat(path + "/cgroup.type"); err != nil {
 		return fmt.Errorf("needed controller not present on root: %+q controllers: %s", path, missing)
 	}
 
 	// TODO: We could cache the controllers that are enabled on each controllers?
 	parentPath := path + "/.."
-	if err := enableControllers(ctx, parentPath, missing); err != nil {
+	if err := enableControllers(ctx, fsys, parentPath, missing); err != nil {
 		return err
 	}
 
@@ -217,6 +244,6 @@ func enableControllers(ctx context.Context, path string, needed []string) error
 		content += " +" + s
 	}
 
-	return os.WriteFile(parentPath+"/cgroup.subtree_control", []byte(content), 0)
+	return fsys.WriteFile(parentPath+"/cgroup.subtree_control", []byte(content))
 
 }
@@ -2,7 +2,12 @@ package minicgroups_test
 
 import (
 	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"gitlab.com/croepha/common-utils/logging"
@@ -51,6 +56,77 @@ func TestSuccess(t *testing.T) {
 
 }
 
+func TestDeleteRecursive(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cgm, err := minicgroups.NewMount(ctx)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, cgm.Done(ctx)) }()
+
+	g, err := cgm.CreateGroup(ctx, nil)
+	require.NoError(t, err)
+	root := g.Path()
+
+	leaf, err := minicgroups.Create(ctx, root+"/a/b", nil)
+	require.NoError(t, err)
+	_, err = minicgroups.Create(ctx, root+"/c", nil)
+	require.NoError(t, err)
+
+	cmd := exec.Command("sleep", "1000")
+	require.NoError(t, cmd.Start())
+	require.NoError(t, leaf.WriteFiles(ctx, map[string]string{
+		"cgroup.procs": strconv.Itoa(cmd.Process.Pid),
+	}))
+
+	found := map[string][]int{}
+	for e, err := range g.Walk(ctx) {
+		require.NoError(t, err)
+		found[e.Group.Path()] = e.Procs
+	}
+	require.Equal(t, map[string][]int{
+		root + "/a/b": {cmd.Process.Pid},
+		root + "/a":   {},
+		root + "/c":   {},
+	}, found)
+
+	require.NoError(t, g.DeleteRecursive(ctx))
+	require.Error(t, cmd.Wait())
+	_, err = os.Stat(root)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// Every private mount sees the same cgroup2 hierarchy, so each one needs its
+// own root, and has to remove it when done
+func TestNewMountRoots(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	a, err := minicgroups.NewMount(ctx)
+	require.NoError(t, err)
+	b, err := minicgroups.NewMount(ctx)
+	require.NoError(t, err)
+	defer func() { require.NoError(t, b.Done(ctx)) }()
+
+	ga, err := a.CreateGroup(ctx, nil)
+	require.NoError(t, err)
+	gb, err := b.CreateGroup(ctx, nil)
+	require.NoError(t, err)
+	aRoot := filepath.Base(filepath.Dir(ga.Path()))
+	require.NotEqual(t, aRoot, filepath.Base(filepath.Dir(gb.Path())))
+
+	cmd := exec.Command("sleep", "1000")
+	require.NoError(t, cmd.Start())
+	require.NoError(t, ga.WriteFiles(ctx, map[string]string{
+		"cgroup.procs": strconv.Itoa(cmd.Process.Pid),
+	}))
+
+	require.NoError(t, a.Done(ctx))
+	require.Error(t, cmd.Wait())
+	_, err = os.Stat(filepath.Dir(filepath.Dir(gb.Path())) + "/" + aRoot)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
 func TestMain(m *testing.M) {
 	logging.SlogStartup()
 	m.Run()
@@ -0,0 +1,197 @@
+package minicgroups
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Resource usage of a group, see Usage and Close
+// Values that the kernel doesn't report (controller not enabled, older
+// kernel) are left as zero
+type Usage struct {
+	CPUUsage  time.Duration // cpu.stat usage_usec
+	CPUUser   time.Duration // cpu.stat user_usec
+	CPUSystem time.Duration // cpu.stat system_usec
+
+	CPUThrottled        time.Duration // cpu.stat throttled_usec
+	CPUThrottledPeriods uint64        // cpu.stat nr_throttled
+
+	MemoryPeak   uint64            // memory.peak, in bytes
+	MemoryEvents map[string]uint64 // memory.events (low, high, max, oom, oom_kill...)
+
+	IO      map[string]IOStat // io.stat, keyed by device "major:minor"
+	IOTotal IOStat            // Sum of IO across all devices
+}
+
+// IO counters for one device, from io.stat
+type IOStat struct {
+	ReadBytes    uint64 // rbytes
+	WriteBytes   uint64 // wbytes
+	ReadIOs      uint64 // rios
+	WriteIOs     uint64 // wios
+	DiscardBytes uint64 // dbytes
+	DiscardIOs   uint64 // dios
+}
+
+func (s *IOStat) add(o IOStat) {
+	s.ReadBytes += o.ReadBytes
+	s.WriteBytes += o.WriteBytes
+	s.ReadIOs += o.ReadIOs
+	s.WriteIOs += o.WriteIOs
+	s.DiscardBytes += o.DiscardBytes
+	s.DiscardIOs += o.DiscardIOs
+}
+
+// Implements slog.LogValuer
+func (u Usage) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Duration("cpuUsage", u.CPUUsage),
+		slog.Duration("cpuUser", u.CPUUser),
+		slog.Duration("cpuSystem", u.CPUSystem),
+		slog.Duration("cpuThrottled", u.CPUThrottled),
+		slog.Uint64("cpuThrottledPeriods", u.CPUThrottledPeriods),
+		slog.Uint64("memoryPeak", u.MemoryPeak),
+		slog.Any("memoryEvents", u.MemoryEvents),
+		slog.Uint64("ioReadBytes", u.IOTotal.ReadBytes),
+		slog.Uint64("ioWriteBytes", u.IOTotal.WriteBytes),
+		slog.Uint64("ioReadIOs", u.IOTotal.ReadIOs),
+		slog.Uint64("ioWriteIOs", u.IOTotal.WriteIOs),
+	)
+}
+
+// Reads the current resource usage of the group (including descendants)
+func (cg *group) Usage(ctx context.Context) (Usage, error) {
+	if cg.path == "" {
+		panic("cgroup not opened")
+	}
+	u := Usage{MemoryEvents: map[string]uint64{}, IO: map[string]IOStat{}}
+
+	cpu, err := readOptionalKeyValues(cg.fs, cg.path+"/cpu.stat")
+	if err != nil {
+		return u, err
+	}
+	u.CPUUsage = time.Duration(cpu["usage_usec"]) * time.Microsecond
+	u.CPUUser = time.Duration(cpu["user_usec"]) * time.Microsecond
+	u.CPUSystem = time.Duration(cpu["system_usec"]) * time.Microsecond
+	u.CPUThrottled = time.Duration(cpu["throttled_usec"]) * time.Microsecond
+	u.CPUThrottledPeriods = cpu["nr_throttled"]
+
+	if fb, err := cg.fs.ReadFile(cg.path + "/memory.peak"); err == nil {
+		u.MemoryPeak, err = strconv.ParseUint(strings.TrimSpace(string(fb)), 10, 64)
+		if err != nil {
+			return u, fmt.Errorf("parsing memory.peak: %w", err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return u, err
+	}
+
+	if u.MemoryEvents, err = readOptionalKeyValues(cg.fs, cg.path+"/memory.events"); err != nil {
+		return u, err
+	}
+
+	if u.IO, err = readIOStat(cg.fs, cg.path+"/io.stat"); err != nil {
+		return u, err
+	}
+	for _, s := range u.IO {
+		u.IOTotal.add(s)
+	}
+
+	return u, nil
+}
+
+// Tears down the group: kills all processes, waits for them to exit, captures
+// the final resource usage, logs it, then removes the group and descendants.
+// The usage is returned even if removal fails
+func (cg *group) Close(ctx context.Context) (Usage, error) {
+	if cg.path == "" {
+		panic("cgroup not opened")
+	}
+
+	ctx = l.A("mcgPath", cg.path).Context(ctx)
+
+	if err := cg.Kill(ctx); err != nil {
+		return Usage{}, err
+	}
+	if err := cg.WaitEmpty(ctx); err != nil {
+		return Usage{}, err
+	}
+
+	usage, usageErr := cg.Usage(ctx)
+	if usageErr != nil {
+		l.Err(usageErr).Warn(ctx, "mcg Close failed to read final usage")
+	} else {
+		l.A("usage", usage).Info(ctx, "mcg Close")
+	}
+
+	if err := cg.removeTree(ctx); err != nil {
+		if usageErr != nil {
+			return usage, fmt.Errorf(
+				"second error: %w while cleaning up from original error: %w",
+				err, usageErr)
+		}
+		return usage, err
+	}
+	return usage, usageErr
+}
+
+// Like readKeyValues, but returns an empty map if the file doesn't exist
+func readOptionalKeyValues(fsys FS, path string) (map[string]uint64, error) {
+	values, err := readKeyValues(fsys, path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]uint64{}, nil
+	}
+	return values, err
+}
+
+// Parses io.stat, lines look like:
+// 8:16 rbytes=1459200 wbytes=314773504 rios=192 wios=353 dbytes=0 dios=0
+func readIOStat(fsys FS, path string) (map[string]IOStat, error) {
+	stats := map[string]IOStat{}
+	fb, err := fsys.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return stats, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(fb), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		s := IOStat{}
+		for _, kv := range fields[1:] {
+			key, value, ok := strings.Cut(kv, "=")
+			if !ok {
+				continue
+			}
+			v, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parsing %+q key %+q: %w", path, key, err)
+			}
+			switch key {
+			case "rbytes":
+				s.ReadBytes = v
+			case "wbytes":
+				s.WriteBytes = v
+			case "rios":
+				s.ReadIOs = v
+			case "wios":
+				s.WriteIOs = v
+			case "dbytes":
+				s.DiscardBytes = v
+			case "dios":
+				s.DiscardIOs = v
+			}
+		}
+		stats[fields[0]] = s
+	}
+	return stats, nil
+}
@@ -0,0 +1,58 @@
+package minicgroups_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/loggingctx"
+	"gitlab.com/croepha/common-utils/minicgroups"
+)
+
+func TestFakeClose(t *testing.T) {
+	logBuf := bytes.Buffer{}
+	ctx := loggingctx.Context(context.Background(), slog.NewJSONHandler(&logBuf, nil))
+
+	fsys := minicgroups.NewFakeFS("/fake/cgroup", []string{"io", "memory"})
+	g, err := fsys.Mount().CreateGroup(ctx, []string{"io", "memory"})
+	require.NoError(t, err)
+	require.NoError(t, g.WriteFiles(ctx, map[string]string{"cgroup.procs": "1234"}))
+
+	require.NoError(t, fsys.SetFile(g.Path()+"/cpu.stat",
+		"usage_usec 3000\nuser_usec 2000\nsystem_usec 1000\nnr_periods 5\nnr_throttled 2\nthrottled_usec 700"))
+	require.NoError(t, fsys.SetFile(g.Path()+"/memory.peak", "1048576"))
+	require.NoError(t, fsys.SetFile(g.Path()+"/memory.events", "low 0\nhigh 3\nmax 1\noom 1\noom_kill 1"))
+	require.NoError(t, fsys.SetFile(g.Path()+"/io.stat",
+		"8:0 rbytes=100 wbytes=200 rios=1 wios=2 dbytes=0 dios=0\n"+
+			"8:16 rbytes=1000 wbytes=2000 rios=10 wios=20 dbytes=5 dios=1"))
+
+	path := g.Path()
+	usage, err := g.Close(ctx)
+	require.NoError(t, err)
+	require.Equal(t, minicgroups.Usage{
+		CPUUsage:            3 * time.Millisecond,
+		CPUUser:             2 * time.Millisecond,
+		CPUSystem:           time.Millisecond,
+		CPUThrottled:        700 * time.Microsecond,
+		CPUThrottledPeriods: 2,
+		MemoryPeak:          1048576,
+		MemoryEvents:        map[string]uint64{"low": 0, "high": 3, "max": 1, "oom": 1, "oom_kill": 1},
+		IO: map[string]minicgroups.IOStat{
+			"8:0":  {ReadBytes: 100, WriteBytes: 200, ReadIOs: 1, WriteIOs: 2},
+			"8:16": {ReadBytes: 1000, WriteBytes: 2000, ReadIOs: 10, WriteIOs: 20, DiscardBytes: 5, DiscardIOs: 1},
+		},
+		IOTotal: minicgroups.IOStat{
+			ReadBytes: 1100, WriteBytes: 2200, ReadIOs: 11, WriteIOs: 22, DiscardBytes: 5, DiscardIOs: 1,
+		},
+	}, usage)
+
+	require.Contains(t, logBuf.String(), `"msg":"mcg Close"`)
+	require.Contains(t, logBuf.String(), `"memoryPeak":1048576`)
+
+	_, err = fsys.Stat(path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
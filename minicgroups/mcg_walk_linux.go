@@ -0,0 +1,300 @@
+package minicgroups
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"iter"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often we check cgroup.events while waiting for a group to empty
+const pollInterval = 10 * time.Millisecond
+
+// Opens a handle to an existing v2 cgroup at path
+// Useful for finding groups that were leaked by a previous run
+func Open(ctx context.Context, path string) (*group, error) {
+	return OpenFS(ctx, OSFS, path)
+}
+
+// Like Open, but does all file operations through fsys
+func OpenFS(ctx context.Context, fsys FS, path string) (*group, error) {
+	if _, err := fsys.Stat(path + "/cgroup.procs"); err != nil {
+		return nil, err
+	}
+	return &group{path: path, fs: fsys}, nil
+}
+
+// Filesystem path of the group
+func (cg *group) Path() string {
+	if cg.path == "" {
+		panic("cgroup not opened")
+	}
+	return cg.path
+}
+
+// Controllers that are available in this group (cgroup.controllers)
+func (cg *group) Controllers(ctx context.Context) ([]string, error) {
+	if cg.path == "" {
+		panic("cgroup not opened")
+	}
+	fb, err := cg.fs.ReadFile(cg.path + "/cgroup.controllers")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(fb)), nil
+}
+
+// Pids of the processes that are members of this group (cgroup.procs)
+func (cg *group) Procs(ctx context.Context) ([]int, error) {
+	if cg.path == "" {
+		panic("cgroup not opened")
+	}
+	return readPidList(cg.fs, cg.path+"/cgroup.procs")
+}
+
+// Lists the immediate child groups
+func (cg *group) Children(ctx context.Context) ([]*group, error) {
+	if cg.path == "" {
+		panic("cgroup not opened")
+	}
+	entries, err := cg.fs.ReadDir(cg.path)
+	if err != nil {
+		return nil, err
+	}
+	children := []*group{}
+	for _, e := range entries {
+		if e.IsDir() {
+			children = append(children, &group{path: cg.path + "/" + e.Name(), fs: cg.fs})
+		}
+	}
+	return children, nil
+}
+
+// Describes a group found by Walk
+type WalkEntry struct {
+	Group       *group
+	Depth       int // 1 for immediate children
+	Controllers []string
+	Procs       []int
+}
+
+// Walks all of the descendants of the group (not including the group itself)
+// Children are yielded before their parents, so the sequence can be used to
+// remove leaves first.  Groups that disappear during the walk are skipped
+func (cg *group) Walk(ctx context.Context) iter.Seq2[WalkEntry, error] {
+	if cg.path == "" {
+		panic("cgroup not opened")
+	}
+	return func(yield func(WalkEntry, error) bool) {
+		walkGroup(ctx, cg, 1, yield)
+	}
+}
+
+// returns false if the walk should stop
+func walkGroup(ctx context.Context, cg *group, depth int, yield func(WalkEntry, error) bool) bool {
+	children, err := cg.Children(ctx)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+	if err != nil {
+		return yield(WalkEntry{}, err)
+	}
+
+	for _, child := range children {
+		if err := ctx.Err(); err != nil {
+			return yield(WalkEntry{}, err)
+		}
+		if !walkGroup(ctx, child, depth+1, yield) {
+			return false
+		}
+
+		controllers, err := child.Controllers(ctx)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return yield(WalkEntry{}, err)
+		}
+		procs, err := child.Procs(ctx)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return yield(WalkEntry{}, err)
+		}
+
+		if !yield(WalkEntry{
+			Group:       child,
+			Depth:       depth,
+			Controllers: controllers,
+			Procs:       procs,
+		}, nil) {
+			return false
+		}
+	}
+	return true
+}
+
+// Sends SIGKILL to every process in the group and its descendants
+// Uses cgroup.kill when the kernel supports it, otherwise freezes the group
+// and signals each pid until none are left
+func (cg *group) Kill(ctx context.Context) error {
+	if cg.path == "" {
+		panic("cgroup not opened")
+	}
+
+	err := cg.fs.WriteFile(cg.path+"/cgroup.kill", []byte("1"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	// Older kernels don't have cgroup.kill.  Frozen processes can't fork, but
+	// still die from SIGKILL.  The root group can't be frozen, so keep
+	// signalling until a read finds no pids, in case any forked in between
+	err = cg.fs.WriteFile(cg.path+"/cgroup.freeze", []byte("1"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	for {
+		pids, err := cg.allProcs(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pids) == 0 {
+			break
+		}
+		for _, pid := range pids {
+			if err := killPid(cg.fs, pid); err != nil {
+				return fmt.Errorf("kill %d: %w", pid, err)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("minicgroups Kill cancelled: %w", ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+
+	// A frozen group would freeze whatever is moved into it next
+	err = cg.fs.WriteFile(cg.path+"/cgroup.freeze", []byte("0"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// The pids in the group and all of its descendants
+func (cg *group) allProcs(ctx context.Context) ([]int, error) {
+	pids, err := cg.Procs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for e, err := range cg.Walk(ctx) {
+		if err != nil {
+			return nil, err
+		}
+		pids = append(pids, e.Procs...)
+	}
+	return pids, nil
+}
+
+// Blocks until there are no processes left in the group or any of its descendants
+func (cg *group) WaitEmpty(ctx context.Context) error {
+	if cg.path == "" {
+		panic("cgroup not opened")
+	}
+
+	// TODO: cgroup.events supports inotify/poll, that would be nicer than sleeping
+	for {
+		events, err := readKeyValues(cg.fs, cg.path+"/cgroup.events")
+		if err != nil {
+			return err
+		}
+		if events["populated"] == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("minicgroups WaitEmpty cancelled: %w", ctx.Err())
+		case <-time.After(pollInterval):
+		}
+	}
+}
+
+// Kills all processes in the group, waits for them to exit, then removes the
+// group and all of its descendants (leaves first)
+func (cg *group) DeleteRecursive(ctx context.Context) error {
+	if cg.path == "" {
+		panic("cgroup not opened")
+	}
+
+	ctx = l.A("mcgPath", cg.path).Context(ctx)
+	l.Debug(ctx, "mcg DeleteRecursive")
+
+	if err := cg.Kill(ctx); err != nil {
+		return err
+	}
+	if err := cg.WaitEmpty(ctx); err != nil {
+		return err
+	}
+	return cg.removeTree(ctx)
+}
+
+// Removes the group and all of its descendants (leaves first)
+// The groups must already be empty
+func (cg *group) removeTree(ctx context.Context) error {
+	for e, err := range cg.Walk(ctx) {
+		if err != nil {
+			return err
+		}
+		if err := cg.fs.Remove(e.Group.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		l.A("mcgChildPath", e.Group.path).Debug(ctx, "mcg DeleteRecursive removed child")
+	}
+
+	return cg.Delete(ctx)
+}
+
+// Parses files with one pid per line, like cgroup.procs
+func readPidList(fsys FS, path string) ([]int, error) {
+	fb, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pids := []int{}
+	for _, s := range strings.Fields(string(fb)) {
+		pid, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %+q: %w", path, err)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// Parses flat keyed files like cgroup.events or cpu.stat
+// Lines look like: "key value"
+func readKeyValues(fsys FS, path string) (map[string]uint64, error) {
+	fb, err := fsys.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]uint64{}
+	for _, line := range strings.Split(string(fb), "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parsing %+q key %+q: %w", path, key, err)
+		}
+		values[key] = v
+	}
+	return values, nil
+}
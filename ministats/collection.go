@@ -1,6 +1,7 @@
 package ministats
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 	"sync/atomic"
@@ -51,15 +52,30 @@ func (g *group) Remove() {
 
 	g.mut.Lock()
 	defer g.mut.Unlock()
-
+	g.removed = true
+	g.clct.wake()
 }
 
+// Returned by TryAddNamed when the name belongs to a group that wasn't removed
+var ErrNameUsed = errors.New("name already used")
+
 // Creates a new group of counters to monitor
 // The given counters can be identified by the key of the map
 // This returns a handle to the group to control varous
 // things... Most notablly, AfterChange() which should be called after updating
 // the counters
+// Panics if the name is already used, see TryAddNamed
 func (s *Service) AddNamed(name string, counters map[string]*atomic.Uint64) *group {
+	g, err := s.TryAddNamed(name, counters)
+	if err != nil {
+		panic(err.Error())
+	}
+	return g
+}
+
+// Like AddNamed, but returns ErrNameUsed instead of panicking
+// The name of a removed group can be reused right away
+func (s *Service) TryAddNamed(name string, counters map[string]*atomic.Uint64) (*group, error) {
 	s.mut.Lock()
 	defer s.mut.Unlock()
 
@@ -67,14 +83,19 @@ func (s *Service) AddNamed(name string, counters map[string]*atomic.Uint64) *gro
 		s.groups = map[string]*group{}
 	}
 
-	if _, ok := s.groups[name]; ok {
-		panic(fmt.Sprintf("name already used: %+q", name))
+	if old, ok := s.groups[name]; ok {
+		old.mut.Lock()
+		removed := old.removed
+		old.mut.Unlock()
+		if !removed {
+			return nil, fmt.Errorf("%w: %+q", ErrNameUsed, name)
+		}
 	}
 
 	g := &group{clct: s, vals: counters}
 	s.groups[name] = g
 	g.AfterChange()
-	return g
+	return g, nil
 }
 
 // Like AddNamed, but just for one counter
@@ -82,6 +103,11 @@ func (s *Service) Add(name string, counter *atomic.Uint64) *group {
 	return s.AddNamed(name, map[string]*atomic.Uint64{"": counter})
 }
 
+// Like TryAddNamed, but just for one counter
+func (s *Service) TryAdd(name string, counter *atomic.Uint64) (*group, error) {
+	return s.TryAddNamed(name, map[string]*atomic.Uint64{"": counter})
+}
+
 // Returns true if there are any changes
 func (s *Service) loadAllStats(values map[string]uint64) bool {
 	s.mut.RLock()
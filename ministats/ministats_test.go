@@ -172,4 +172,20 @@ func TestBasic(t *testing.T) {
 
 }
 
+func TestRemoveFreesName(t *testing.T) {
+	c := ministats.Service{}
+	v := atomic.Uint64{}
+
+	g := c.Add("group0", &v)
+	_, err := c.TryAdd("group0", &v)
+	require.ErrorIs(t, err, ministats.ErrNameUsed)
+	require.PanicsWithValue(t, `name already used: "group0"`, func() { c.Add("group0", &v) })
+
+	g.Remove()
+	g, err = c.TryAdd("group0", &v)
+	require.NoError(t, err)
+	g.Remove()
+	require.NotPanics(t, func() { c.Add("group0", &v) })
+}
+
 // TODO: Add torture/scale test
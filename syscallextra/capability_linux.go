@@ -0,0 +1,184 @@
+package syscallextra
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// A capability number, like unix.CAP_SYS_ADMIN, see capabilities(7)
+type Capability int
+
+// Indexed by capability number, without the CAP_ prefix
+var capabilityNames = []string{
+	"CHOWN", "DAC_OVERRIDE", "DAC_READ_SEARCH", "FOWNER", "FSETID", "KILL",
+	"SETGID", "SETUID", "SETPCAP", "LINUX_IMMUTABLE", "NET_BIND_SERVICE",
+	"NET_BROADCAST", "NET_ADMIN", "NET_RAW", "IPC_LOCK", "IPC_OWNER",
+	"SYS_MODULE", "SYS_RAWIO", "SYS_CHROOT", "SYS_PTRACE", "SYS_PACCT",
+	"SYS_ADMIN", "SYS_BOOT", "SYS_NICE", "SYS_RESOURCE", "SYS_TIME",
+	"SYS_TTY_CONFIG", "MKNOD", "LEASE", "AUDIT_WRITE", "AUDIT_CONTROL",
+	"SETFCAP", "MAC_OVERRIDE", "MAC_ADMIN", "SYSLOG", "WAKE_ALARM",
+	"BLOCK_SUSPEND", "AUDIT_READ", "PERFMON", "BPF", "CHECKPOINT_RESTORE",
+}
+
+// Like "CAP_SYS_ADMIN", capabilities newer than this package are "CAP_41"...
+func (c Capability) String() string {
+	if c >= 0 && int(c) < len(capabilityNames) {
+		return "CAP_" + capabilityNames[c]
+	}
+	return fmt.Sprintf("CAP_%d", int(c))
+}
+
+// Parses a capability name, like "CAP_SYS_ADMIN" or "sys_admin"
+func ParseCapability(name string) (Capability, error) {
+	upper := strings.TrimPrefix(strings.ToUpper(name), "CAP_")
+	for i, n := range capabilityNames {
+		if n == upper {
+			return Capability(i), nil
+		}
+	}
+	if v, err := strconv.Atoi(upper); err == nil && v >= 0 && v < 64 {
+		return Capability(v), nil
+	}
+	return 0, fmt.Errorf("unknown capability: %+q", name)
+}
+
+// A set of capabilities, as a bitmask like in /proc/<pid>/status
+type CapSet uint64
+
+func (s CapSet) Has(c Capability) bool {
+	return c >= 0 && c < 64 && s&(1<<c) != 0
+}
+
+// The capabilities in the set, in numeric order
+func (s CapSet) List() []Capability {
+	caps := []Capability{}
+	for c := Capability(0); c < 64; c++ {
+		if s.Has(c) {
+			caps = append(caps, c)
+		}
+	}
+	return caps
+}
+
+// Like "CAP_CHOWN,CAP_KILL", or "none"
+func (s CapSet) String() string {
+	if s == 0 {
+		return "none"
+	}
+	names := []string{}
+	for _, c := range s.List() {
+		names = append(names, c.String())
+	}
+	return strings.Join(names, ",")
+}
+
+// The capability sets of a thread, see capabilities(7)
+type Capabilities struct {
+	Inheritable CapSet
+	Permitted   CapSet
+	Effective   CapSet // What the kernel checks
+	Bounding    CapSet // Limit on what execve can grant
+	Ambient     CapSet // Kept across execve of unprivileged programs
+}
+
+// Reads the capabilities of the calling thread
+// NOTE: Capabilities are per thread, they only differ between threads if
+// something changed them with capset(2) on one thread
+func SelfCapabilities() (Capabilities, error) {
+	return ReadCapabilities("/proc/thread-self/status")
+}
+
+// Reads the capabilities from a /proc/<pid>/status file
+func ReadCapabilities(statusPath string) (Capabilities, error) {
+	f, err := os.Open(statusPath)
+	if err != nil {
+		return Capabilities{}, err
+	}
+	defer f.Close()
+	caps, err := ParseCapabilities(f)
+	if err != nil {
+		return caps, fmt.Errorf("%s: %w", statusPath, err)
+	}
+	return caps, nil
+}
+
+// Parses the Cap* lines of /proc/<pid>/status formatted text
+func ParseCapabilities(r io.Reader) (Capabilities, error) {
+	caps := Capabilities{}
+	fields := map[string]*CapSet{
+		"CapInh": &caps.Inheritable,
+		"CapPrm": &caps.Permitted,
+		"CapEff": &caps.Effective,
+		"CapBnd": &caps.Bounding,
+		"CapAmb": &caps.Ambient,
+	}
+	found := 0
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		key, value, ok := strings.Cut(s.Text(), ":")
+		if !ok {
+			continue
+		}
+		target, ok := fields[key]
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseUint(strings.TrimSpace(value), 16, 64)
+		if err != nil {
+			return caps, fmt.Errorf("malformed %s line: %+q", key, s.Text())
+		}
+		*target = CapSet(v)
+		found++
+	}
+	if err := s.Err(); err != nil {
+		return caps, err
+	}
+	// Ambient is missing before Linux 4.3
+	if found < len(fields)-1 {
+		return caps, errors.New("capability sets not found")
+	}
+	return caps, nil
+}
+
+// Returns an error explaining which of the caps aren't effective, and why,
+// or nil if all are.  Meant for startup diagnostics like:
+//
+//	missing CAP_SYS_ADMIN (not in the bounding set, so it can't be gained by running as root)
+func (c Capabilities) Require(caps ...Capability) error {
+	errs := []error{}
+	for _, cp := range caps {
+		if c.Effective.Has(cp) {
+			continue
+		}
+		var why string
+		switch {
+		case c.Permitted.Has(cp):
+			why = "permitted but not effective"
+		case !c.Bounding.Has(cp):
+			why = "not in the bounding set, so it can't be gained by running as root"
+		case os.Geteuid() == 0:
+			why = "not permitted, even though running as root"
+		default:
+			why = "not permitted, run as root or give the program file capabilities"
+		}
+		errs = append(errs, fmt.Errorf("missing %s (%s)", cp, why))
+	}
+	return errors.Join(errs...)
+}
+
+// Shorthand for Require(CAP_SYS_ADMIN) on the calling thread, which is needed
+// for mounting and most namespace operations
+func RequireSysAdmin() error {
+	caps, err := SelfCapabilities()
+	if err != nil {
+		return err
+	}
+	return caps.Require(Capability(unix.CAP_SYS_ADMIN))
+}
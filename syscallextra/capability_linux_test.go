@@ -0,0 +1,73 @@
+package syscallextra_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/syscallextra"
+	"golang.org/x/sys/unix"
+)
+
+const containerStatus = `Name:	sh
+Umask:	0022
+State:	S (sleeping)
+CapInh:	0000000000000000
+CapPrm:	00000000a80425fb
+CapEff:	00000000a80425fb
+CapBnd:	00000000a80425fb
+CapAmb:	0000000000000000
+NoNewPrivs:	0
+`
+
+func TestParseCapabilities(t *testing.T) {
+	caps, err := syscallextra.ParseCapabilities(strings.NewReader(containerStatus))
+	require.NoError(t, err)
+	require.Equal(t, syscallextra.CapSet(0xa80425fb), caps.Effective)
+	require.Equal(t, syscallextra.CapSet(0), caps.Ambient)
+
+	require.True(t, caps.Effective.Has(unix.CAP_CHOWN))
+	require.True(t, caps.Effective.Has(unix.CAP_NET_BIND_SERVICE))
+	require.False(t, caps.Effective.Has(unix.CAP_SYS_ADMIN))
+	require.Equal(t, "CAP_CHOWN,CAP_DAC_OVERRIDE,CAP_FOWNER,CAP_FSETID,CAP_KILL,CAP_SETGID,"+
+		"CAP_SETUID,CAP_SETPCAP,CAP_NET_BIND_SERVICE,CAP_NET_RAW,CAP_SYS_CHROOT,CAP_MKNOD,"+
+		"CAP_AUDIT_WRITE,CAP_SETFCAP", caps.Effective.String())
+
+	require.NoError(t, caps.Require(unix.CAP_CHOWN, unix.CAP_KILL))
+	err = caps.Require(unix.CAP_SYS_ADMIN)
+	require.ErrorContains(t, err, "missing CAP_SYS_ADMIN (not in the bounding set")
+
+	caps.Effective = 0
+	require.ErrorContains(t, caps.Require(unix.CAP_CHOWN), "missing CAP_CHOWN (permitted but not effective)")
+
+	_, err = syscallextra.ParseCapabilities(strings.NewReader("Name:\tsh\n"))
+	require.Error(t, err)
+	_, err = syscallextra.ParseCapabilities(strings.NewReader("CapEff:\tzz\n"))
+	require.Error(t, err)
+}
+
+func TestCapabilityNames(t *testing.T) {
+	require.Equal(t, "CAP_SYS_ADMIN", syscallextra.Capability(unix.CAP_SYS_ADMIN).String())
+	require.Equal(t, "CAP_CHECKPOINT_RESTORE", syscallextra.Capability(unix.CAP_CHECKPOINT_RESTORE).String())
+	require.Equal(t, "CAP_63", syscallextra.Capability(63).String())
+
+	for _, name := range []string{"CAP_SYS_ADMIN", "sys_admin", "21"} {
+		c, err := syscallextra.ParseCapability(name)
+		require.NoError(t, err)
+		require.Equal(t, syscallextra.Capability(unix.CAP_SYS_ADMIN), c)
+	}
+	_, err := syscallextra.ParseCapability("CAP_BOGUS")
+	require.Error(t, err)
+}
+
+func TestSelfCapabilities(t *testing.T) {
+	caps, err := syscallextra.SelfCapabilities()
+	require.NoError(t, err)
+	// Effective is always a subset of permitted
+	require.Equal(t, caps.Effective, caps.Effective&caps.Permitted)
+	if caps.Effective.Has(unix.CAP_SYS_ADMIN) {
+		require.NoError(t, syscallextra.RequireSysAdmin())
+	} else {
+		require.ErrorContains(t, syscallextra.RequireSysAdmin(), "missing CAP_SYS_ADMIN")
+	}
+}
@@ -0,0 +1,429 @@
+package syscallextra
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Blocks until fd has one of the poll(2) events, or ctx is done
+// Returns the revents, which can also be POLLERR or POLLHUP, and EBADF when
+// fd isn't open (POLLNVAL).  Uses an eventfd to wake poll when ctx is done
+func PollContext(ctx context.Context, fd int, events int16) (int16, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	wake, err := NewEventFD(0)
+	if err != nil {
+		return 0, err
+	}
+	defer wake.Close()
+	stop := wakeOnDone(ctx, wake)
+	defer stop()
+
+	fds := []unix.PollFd{
+		{Fd: int32(fd), Events: events},
+		{Fd: int32(wake.fd), Events: unix.POLLIN},
+	}
+	if err := WrapEINTR(func() error {
+		_, err := unix.Poll(fds, -1)
+		return err
+	}); err != nil {
+		return 0, os.NewSyscallError("poll", err)
+	}
+	if fds[0].Revents&unix.POLLNVAL != 0 {
+		return 0, os.NewSyscallError("poll", unix.EBADF)
+	}
+	if fds[0].Revents != 0 {
+		return fds[0].Revents, nil
+	}
+	return 0, ctx.Err()
+}
+
+// Signals wake when ctx is done, the returned func must be called before wake
+// is closed, it waits for the signal if it already started
+func wakeOnDone(ctx context.Context, wake *EventFD) func() {
+	woken := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(woken)
+		_ = wake.Add(1)
+	})
+	return func() {
+		if !stop() {
+			<-woken
+		}
+	}
+}
+
+// A counter from eventfd(2), readable while the counter is non zero
+// Always non blocking and close on exec
+type EventFD struct {
+	fd int
+}
+
+// Creates an eventfd with the initial counter value
+func NewEventFD(initval uint) (*EventFD, error) {
+	var fd int
+	if err := WrapEINTR(func() (err error) {
+		fd, err = unix.Eventfd(initval, unix.EFD_CLOEXEC|unix.EFD_NONBLOCK)
+		return
+	}); err != nil {
+		return nil, os.NewSyscallError("eventfd", err)
+	}
+	return &EventFD{fd: fd}, nil
+}
+
+// The raw fd, for adding to an Epoll
+func (e *EventFD) Fd() int {
+	if e.fd < 0 {
+		panic("eventfd closed")
+	}
+	return e.fd
+}
+
+// Closes the eventfd, it is an error to use it afterwards
+func (e *EventFD) Close() error {
+	if e.fd < 0 {
+		panic("eventfd closed")
+	}
+	err := unix.Close(e.fd)
+	e.fd = -1
+	return err
+}
+
+// Adds n to the counter
+func (e *EventFD) Add(n uint64) error {
+	if e.fd < 0 {
+		panic("eventfd closed")
+	}
+	return os.NewSyscallError("write eventfd", WrapEINTR(func() error {
+		_, err := unix.Write(e.fd, binary.NativeEndian.AppendUint64(nil, n))
+		return err
+	}))
+}
+
+// Returns the counter and resets it to 0, or EAGAIN if it is already 0
+func (e *EventFD) Read() (uint64, error) {
+	if e.fd < 0 {
+		panic("eventfd closed")
+	}
+	return readUint64(e.fd, "read eventfd")
+}
+
+// Waits for the counter to be non zero or ctx to be done, then does Read
+func (e *EventFD) Wait(ctx context.Context) (uint64, error) {
+	return waitUint64(ctx, e.Fd(), e.Read)
+}
+
+// A timer from timerfd_create(2), readable once it has expired
+// Always non blocking and close on exec
+type TimerFD struct {
+	fd int
+}
+
+// Creates a disarmed timer on clockid, like unix.CLOCK_MONOTONIC
+func NewTimerFD(clockid int) (*TimerFD, error) {
+	var fd int
+	if err := WrapEINTR(func() (err error) {
+		fd, err = unix.TimerfdCreate(clockid, unix.TFD_CLOEXEC|unix.TFD_NONBLOCK)
+		return
+	}); err != nil {
+		return nil, os.NewSyscallError("timerfd_create", err)
+	}
+	return &TimerFD{fd: fd}, nil
+}
+
+// The raw fd, for adding to an Epoll
+func (t *TimerFD) Fd() int {
+	if t.fd < 0 {
+		panic("timerfd closed")
+	}
+	return t.fd
+}
+
+// Closes the timerfd, it is an error to use it afterwards
+func (t *TimerFD) Close() error {
+	if t.fd < 0 {
+		panic("timerfd closed")
+	}
+	err := unix.Close(t.fd)
+	t.fd = -1
+	return err
+}
+
+// Arms the timer to expire after initial, then every interval (0 for once)
+// An initial of 0 disarms it, see Stop
+func (t *TimerFD) Set(initial, interval time.Duration) error {
+	if t.fd < 0 {
+		panic("timerfd closed")
+	}
+	spec := unix.ItimerSpec{
+		Value:    unix.NsecToTimespec(initial.Nanoseconds()),
+		Interval: unix.NsecToTimespec(interval.Nanoseconds()),
+	}
+	return os.NewSyscallError("timerfd_settime", WrapEINTR(func() error {
+		return unix.TimerfdSettime(t.fd, 0, &spec, nil)
+	}))
+}
+
+// Disarms the timer
+func (t *TimerFD) Stop() error {
+	return t.Set(0, 0)
+}
+
+// Returns the number of expirations since the last Read, or EAGAIN if none
+func (t *TimerFD) Read() (uint64, error) {
+	if t.fd < 0 {
+		panic("timerfd closed")
+	}
+	return readUint64(t.fd, "read timerfd")
+}
+
+// Waits for the timer to expire or ctx to be done, then does Read
+func (t *TimerFD) Wait(ctx context.Context) (uint64, error) {
+	return waitUint64(ctx, t.Fd(), t.Read)
+}
+
+// Receives signals through signalfd(2)
+// NOTE: Only signals that are blocked get queued to the signalfd, and the Go
+// runtime doesn't block signals in all its threads, so a process directed
+// signal usually goes to the Go signal handler instead.  This is reliable for
+// signals sent to a thread (tgkill) that blocks them, which needs
+// runtime.LockOSThread and unix.PthreadSigmask
+type SignalFD struct {
+	fd int
+}
+
+// Creates a signalfd that receives sigs, always non blocking and close on exec
+func NewSignalFD(sigs ...syscall.Signal) (*SignalFD, error) {
+	for _, sig := range sigs {
+		if sig < 1 || sig > maxSignal {
+			return nil, os.NewSyscallError("signalfd", unix.EINVAL)
+		}
+	}
+	mask := SignalSet(sigs...)
+	var fd int
+	if err := WrapEINTR(func() (err error) {
+		fd, err = unix.Signalfd(-1, &mask, unix.SFD_CLOEXEC|unix.SFD_NONBLOCK)
+		return
+	}); err != nil {
+		return nil, os.NewSyscallError("signalfd", err)
+	}
+	return &SignalFD{fd: fd}, nil
+}
+
+// The kernel's _NSIG, signals are numbered from 1
+const maxSignal = 64
+
+// Builds a signal mask, for NewSignalFD or unix.PthreadSigmask
+// Signals outside of 1 to 64 are skipped
+func SignalSet(sigs ...syscall.Signal) unix.Sigset_t {
+	set := unix.Sigset_t{}
+	bits := uint(unsafe.Sizeof(set.Val[0])) * 8
+	for _, sig := range sigs {
+		if sig < 1 || sig > maxSignal {
+			continue
+		}
+		n := uint(sig) - 1
+		set.Val[n/bits] |= 1 << (n % bits)
+	}
+	return set
+}
+
+// The raw fd, for adding to an Epoll
+func (s *SignalFD) Fd() int {
+	if s.fd < 0 {
+		panic("signalfd closed")
+	}
+	return s.fd
+}
+
+// Closes the signalfd, it is an error to use it afterwards
+func (s *SignalFD) Close() error {
+	if s.fd < 0 {
+		panic("signalfd closed")
+	}
+	err := unix.Close(s.fd)
+	s.fd = -1
+	return err
+}
+
+// Dequeues one signal, or returns EAGAIN if none is pending
+func (s *SignalFD) Read() (unix.SignalfdSiginfo, error) {
+	if s.fd < 0 {
+		panic("signalfd closed")
+	}
+	info := unix.SignalfdSiginfo{}
+	buf := unsafe.Slice((*byte)(unsafe.Pointer(&info)), unsafe.Sizeof(info))
+	err := WrapEINTR(func() error {
+		_, err := unix.Read(s.fd, buf)
+		return err
+	})
+	return info, os.NewSyscallError("read signalfd", err)
+}
+
+// Waits for a signal or ctx to be done, then does Read
+func (s *SignalFD) Wait(ctx context.Context) (unix.SignalfdSiginfo, error) {
+	for {
+		if _, err := PollContext(ctx, s.Fd(), unix.POLLIN); err != nil {
+			return unix.SignalfdSiginfo{}, err
+		}
+		info, err := s.Read()
+		// Someone else could have read it first
+		if !errors.Is(err, unix.EAGAIN) {
+			return info, err
+		}
+	}
+}
+
+func readUint64(fd int, op string) (uint64, error) {
+	buf := make([]byte, 8)
+	if err := WrapEINTR(func() error {
+		_, err := unix.Read(fd, buf)
+		return err
+	}); err != nil {
+		return 0, os.NewSyscallError(op, err)
+	}
+	return binary.NativeEndian.Uint64(buf), nil
+}
+
+func waitUint64(ctx context.Context, fd int, read func() (uint64, error)) (uint64, error) {
+	for {
+		if _, err := PollContext(ctx, fd, unix.POLLIN); err != nil {
+			return 0, err
+		}
+		n, err := read()
+		// Someone else could have read it first
+		if !errors.Is(err, unix.EAGAIN) {
+			return n, err
+		}
+	}
+}
+
+// An epoll(7) instance, for waiting on many fds (Pidfd, EventFD, TimerFD,
+// SignalFD, cgroup.events...) in one loop, with Wait taking a context
+// Wait shouldn't be called from several goroutines at once
+type Epoll struct {
+	fd   int
+	wake *EventFD
+}
+
+// Sentinel stored in the wake eventfd's event data, no real fd is negative
+const epollWakeData = -1
+
+// Creates an epoll instance, close on exec
+func NewEpoll() (*Epoll, error) {
+	var fd int
+	if err := WrapEINTR(func() (err error) {
+		fd, err = unix.EpollCreate1(unix.EPOLL_CLOEXEC)
+		return
+	}); err != nil {
+		return nil, os.NewSyscallError("epoll_create1", err)
+	}
+	wake, err := NewEventFD(0)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	e := &Epoll{fd: fd, wake: wake}
+	if err := e.ctl(unix.EPOLL_CTL_ADD, wake.fd, unix.EPOLLIN, epollWakeData); err != nil {
+		e.Close()
+		return nil, err
+	}
+	return e, nil
+}
+
+// The raw epoll fd, which is itself pollable
+func (e *Epoll) Fd() int {
+	if e.fd < 0 {
+		panic("epoll closed")
+	}
+	return e.fd
+}
+
+// Closes the epoll instance, the fds added to it aren't closed
+func (e *Epoll) Close() error {
+	if e.fd < 0 {
+		panic("epoll closed")
+	}
+	err := errors.Join(unix.Close(e.fd), e.wake.Close())
+	e.fd = -1
+	return err
+}
+
+// Starts watching fd for events (EPOLLIN, EPOLLOUT, EPOLLPRI, EPOLLET...),
+// the events returned by Wait have Fd set to fd
+func (e *Epoll) Add(fd int, events uint32) error {
+	return e.ctl(unix.EPOLL_CTL_ADD, fd, events, int32(fd))
+}
+
+// Changes the events fd is watched for
+func (e *Epoll) Modify(fd int, events uint32) error {
+	return e.ctl(unix.EPOLL_CTL_MOD, fd, events, int32(fd))
+}
+
+// Stops watching fd, must be called before fd is closed if the fd could be
+// duplicated, otherwise the kernel keeps reporting it
+func (e *Epoll) Remove(fd int) error {
+	return e.ctl(unix.EPOLL_CTL_DEL, fd, 0, int32(fd))
+}
+
+func (e *Epoll) ctl(op int, fd int, events uint32, data int32) error {
+	if e.fd < 0 {
+		panic("epoll closed")
+	}
+	ev := unix.EpollEvent{Events: events, Fd: data}
+	return os.NewSyscallError("epoll_ctl", WrapEINTR(func() error {
+		return unix.EpollCtl(e.fd, op, fd, &ev)
+	}))
+}
+
+// Blocks until at least one watched fd is ready, or ctx is done
+// Fills events and returns the count, events must not be empty
+func (e *Epoll) Wait(ctx context.Context, events []unix.EpollEvent) (int, error) {
+	if e.fd < 0 {
+		panic("epoll closed")
+	}
+	if len(events) == 0 {
+		panic("epoll Wait with no room for events")
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	stop := wakeOnDone(ctx, e.wake)
+	defer stop()
+
+	for {
+		var n int
+		if err := WrapEINTR(func() (err error) {
+			n, err = unix.EpollWait(e.fd, events, -1)
+			return
+		}); err != nil {
+			return 0, os.NewSyscallError("epoll_wait", err)
+		}
+
+		ready := 0
+		for _, ev := range events[:n] {
+			if ev.Fd == epollWakeData {
+				// Could be left over from an earlier Wait's context
+				_, _ = e.wake.Read()
+				continue
+			}
+			events[ready] = ev
+			ready++
+		}
+		if ready > 0 {
+			return ready, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package syscallextra_test
+
+import (
+	"context"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/syscallextra"
+	"golang.org/x/sys/unix"
+)
+
+func TestEventFD(t *testing.T) {
+	syscallextra.RequireNoFDLeaks(t)
+	e, err := syscallextra.NewEventFD(0)
+	require.NoError(t, err)
+	defer e.Close()
+
+	_, err = e.Read()
+	require.ErrorIs(t, err, unix.EAGAIN)
+
+	require.NoError(t, e.Add(2))
+	require.NoError(t, e.Add(3))
+	n, err := e.Wait(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, uint64(5), n)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = e.Wait(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestTimerFD(t *testing.T) {
+	syscallextra.RequireNoFDLeaks(t)
+	tfd, err := syscallextra.NewTimerFD(unix.CLOCK_MONOTONIC)
+	require.NoError(t, err)
+	defer tfd.Close()
+
+	start := time.Now()
+	require.NoError(t, tfd.Set(20*time.Millisecond, 0))
+	n, err := tfd.Wait(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), n)
+	require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+
+	require.NoError(t, tfd.Set(time.Hour, 0))
+	require.NoError(t, tfd.Stop())
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = tfd.Wait(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestSignalFD(t *testing.T) {
+	syscallextra.RequireNoFDLeaks(t)
+
+	// Only a thread that blocks the signal will queue it for the signalfd
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	set := syscallextra.SignalSet(syscall.SIGUSR1)
+	old := unix.Sigset_t{}
+	require.NoError(t, unix.PthreadSigmask(unix.SIG_BLOCK, &set, &old))
+	defer unix.PthreadSigmask(unix.SIG_SETMASK, &old, nil)
+
+	s, err := syscallextra.NewSignalFD(syscall.SIGUSR1)
+	require.NoError(t, err)
+	defer s.Close()
+
+	require.NoError(t, unix.Tgkill(unix.Getpid(), unix.Gettid(), syscall.SIGUSR1))
+	info, err := s.Wait(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, uint32(syscall.SIGUSR1), info.Signo)
+	require.Equal(t, uint32(unix.Getpid()), info.Pid)
+
+	_, err = s.Read()
+	require.ErrorIs(t, err, unix.EAGAIN)
+}
+
+func TestPollContext(t *testing.T) {
+	syscallextra.RequireNoFDLeaks(t)
+	fds := [2]int{}
+	require.NoError(t, unix.Pipe2(fds[:], unix.O_CLOEXEC))
+	defer unix.Close(fds[0])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := syscallextra.PollContext(ctx, fds[0], unix.POLLIN)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// The write end closing is reported as POLLHUP, not POLLIN
+	require.NoError(t, unix.Close(fds[1]))
+	revents, err := syscallextra.PollContext(context.Background(), fds[0], unix.POLLIN)
+	require.NoError(t, err)
+	require.Equal(t, int16(unix.POLLHUP), revents)
+
+	// A closed fd isn't ready, the number is high so that PollContext's own
+	// eventfd doesn't reuse it
+	require.NoError(t, unix.Dup3(fds[0], 1000, unix.O_CLOEXEC))
+	require.NoError(t, unix.Close(1000))
+	_, err = syscallextra.PollContext(context.Background(), 1000, unix.POLLIN)
+	require.ErrorIs(t, err, unix.EBADF)
+}
+
+func TestSignalSetRange(t *testing.T) {
+	require.Equal(t, syscallextra.SignalSet(syscall.SIGUSR1),
+		syscallextra.SignalSet(0, syscall.SIGUSR1, 65, 1000, -1))
+	_, err := syscallextra.NewSignalFD(syscall.SIGUSR1, 0)
+	require.ErrorIs(t, err, unix.EINVAL)
+	_, err = syscallextra.NewSignalFD(65)
+	require.ErrorIs(t, err, unix.EINVAL)
+}
+
+func TestEpoll(t *testing.T) {
+	syscallextra.RequireNoFDLeaks(t)
+	ep, err := syscallextra.NewEpoll()
+	require.NoError(t, err)
+	defer ep.Close()
+
+	e, err := syscallextra.NewEventFD(0)
+	require.NoError(t, err)
+	defer e.Close()
+	tfd, err := syscallextra.NewTimerFD(unix.CLOCK_MONOTONIC)
+	require.NoError(t, err)
+	defer tfd.Close()
+	cmd, p := startPidfd(t, "sleep", "1000")
+
+	for _, fd := range []int{e.Fd(), tfd.Fd(), p.Fd()} {
+		require.NoError(t, ep.Add(fd, unix.EPOLLIN))
+	}
+	events := make([]unix.EpollEvent, 4)
+
+	// Nothing ready, the context ends the wait
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = ep.Wait(ctx, events)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// A wake left over from the old context doesn't end the next wait early
+	require.NoError(t, tfd.Set(20*time.Millisecond, 0))
+	n, err := ep.Wait(context.Background(), events)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	require.Equal(t, int32(tfd.Fd()), events[0].Fd)
+	_, err = tfd.Read()
+	require.NoError(t, err)
+
+	require.NoError(t, e.Add(1))
+	require.NoError(t, p.Signal(syscall.SIGKILL))
+	_, err = p.Wait(context.Background())
+	require.NoError(t, err)
+	// Reaped by the Pidfd, so drop os/exec's own handle to the process
+	require.NoError(t, cmd.Process.Release())
+	n, err = ep.Wait(context.Background(), events)
+	require.NoError(t, err)
+	ready := []int32{}
+	for _, ev := range events[:n] {
+		ready = append(ready, ev.Fd)
+	}
+	require.ElementsMatch(t, []int32{int32(e.Fd()), int32(p.Fd())}, ready)
+
+	require.NoError(t, ep.Remove(e.Fd()))
+	require.NoError(t, ep.Remove(p.Fd()))
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = ep.Wait(ctx, events)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,197 @@
+package syscallextra
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// Describes one open fd, from /proc/self/fd and /proc/self/fdinfo
+type FDInfo struct {
+	FD      int
+	Target  string // readlink of /proc/self/fd/N, like "/tmp/x", "pipe:[1234]" or "anon_inode:[eventfd]"
+	Type    string // file, dir, symlink, pipe, socket, char, block or anon_inode
+	Flags   int    // open(2) flags, like O_RDWR|O_CLOEXEC
+	Pos     int64
+	MountID int
+	Inode   uint64
+}
+
+func (i FDInfo) String() string {
+	return fmt.Sprintf("fd %d: %s (%s) flags=0%o pos=%d", i.FD, i.Target, i.Type, i.Flags, i.Pos)
+}
+
+// Whether a and b most likely describe the same open file
+func (i FDInfo) same(o FDInfo) bool {
+	return i.FD == o.FD && i.Target == o.Target && i.Inode == o.Inode && i.MountID == o.MountID
+}
+
+// Describes a single open fd
+func DescribeFDInfo(fd int) (FDInfo, error) {
+	info := FDInfo{FD: fd}
+
+	target, err := DescribeOpenFD(fd)
+	if err != nil {
+		return info, err
+	}
+	info.Target = target
+
+	fb, err := os.ReadFile(fmt.Sprintf("/proc/self/fdinfo/%d", fd))
+	if err != nil {
+		return info, err
+	}
+	for _, line := range strings.Split(string(fb), "\n") {
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "pos":
+			info.Pos, err = strconv.ParseInt(value, 10, 64)
+		case "flags":
+			var flags int64
+			flags, err = strconv.ParseInt(value, 8, 64)
+			info.Flags = int(flags)
+		case "mnt_id":
+			info.MountID, err = strconv.Atoi(value)
+		case "ino":
+			info.Inode, err = strconv.ParseUint(value, 10, 64)
+		}
+		if err != nil {
+			return info, fmt.Errorf("parsing fdinfo %d %+q: %w", fd, key, err)
+		}
+	}
+
+	info.Type = "unknown"
+	if strings.HasPrefix(target, "anon_inode:") {
+		info.Type = "anon_inode"
+	} else if st, err := StatxFD(fd, StatxOptions{Flags: unix.AT_SYMLINK_NOFOLLOW, Mask: unix.STATX_TYPE | unix.STATX_INO}); err == nil {
+		info.Type = fdTypeName(st.Mode)
+		if info.Inode == 0 {
+			info.Inode = st.Ino
+		}
+	}
+	return info, nil
+}
+
+func fdTypeName(mode fs.FileMode) string {
+	switch mode.Type() {
+	case 0:
+		return "file"
+	case fs.ModeDir:
+		return "dir"
+	case fs.ModeSymlink:
+		return "symlink"
+	case fs.ModeNamedPipe:
+		return "pipe"
+	case fs.ModeSocket:
+		return "socket"
+	case fs.ModeDevice | fs.ModeCharDevice:
+		return "char"
+	case fs.ModeDevice:
+		return "block"
+	}
+	return "unknown"
+}
+
+// Describes all fds that are open in this process, sorted by fd
+// The fd used to list /proc/self/fd is left out
+func FDInventory() ([]FDInfo, error) {
+	dir, err := os.Open("/proc/self/fd")
+	if err != nil {
+		return nil, err
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := []FDInfo{}
+	for _, name := range names {
+		fd, err := strconv.Atoi(name)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected entry in /proc/self/fd: %+q", name)
+		}
+		if fd == int(dir.Fd()) {
+			continue
+		}
+		info, err := DescribeFDInfo(fd)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Closed while we were looking
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	slices.SortFunc(infos, func(a, b FDInfo) int { return a.FD - b.FD })
+	return infos, nil
+}
+
+// Compares two inventories, returns fds in after that weren't in before
+// (including fds that were closed then reused for something else), and fds
+// in before that are no longer open
+func DiffFDInventory(before, after []FDInfo) (opened, closed []FDInfo) {
+	opened = []FDInfo{}
+	closed = []FDInfo{}
+	for _, a := range after {
+		if !slices.ContainsFunc(before, a.same) {
+			opened = append(opened, a)
+		}
+	}
+	for _, b := range before {
+		if !slices.ContainsFunc(after, b.same) {
+			closed = append(closed, b)
+		}
+	}
+	return opened, closed
+}
+
+// Test helper that fails the test if it ends with fds open that weren't
+// open when this was called.  Call at the start of the test:
+//
+//	syscallextra.RequireNoFDLeaks(t)
+//
+// Tests using this shouldn't run in parallel with others
+func RequireNoFDLeaks(t testing.TB) {
+	t.Helper()
+
+	// The runtime opens its poller fds lazily, make sure that has already
+	// happened so they don't show up as leaks
+	if r, w, err := os.Pipe(); err == nil {
+		r.Close()
+		w.Close()
+	}
+
+	before, err := FDInventory()
+	if err != nil {
+		t.Fatalf("RequireNoFDLeaks: %s", err)
+	}
+	t.Cleanup(func() {
+		t.Helper()
+		after, err := FDInventory()
+		if err != nil {
+			t.Fatalf("RequireNoFDLeaks: %s", err)
+		}
+		leaked, _ := DiffFDInventory(before, after)
+		if len(leaked) == 0 {
+			return
+		}
+		s := strings.Builder{}
+		fmt.Fprintf(&s, "%d fd(s) leaked:", len(leaked))
+		for _, l := range leaked {
+			fmt.Fprintf(&s, "\n\t+ %s", l)
+		}
+		t.Error(s.String())
+	})
+}
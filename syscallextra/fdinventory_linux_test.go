@@ -0,0 +1,96 @@
+package syscallextra_test
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/syscallextra"
+	"golang.org/x/sys/unix"
+)
+
+func TestFDInventory(t *testing.T) {
+	path := t.TempDir() + "/file"
+	require.NoError(t, os.WriteFile(path, []byte("hello"), 0600))
+
+	before, err := syscallextra.FDInventory()
+	require.NoError(t, err)
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	_, err = f.Read(make([]byte, 3))
+	require.NoError(t, err)
+
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+
+	after, err := syscallextra.FDInventory()
+	require.NoError(t, err)
+
+	opened, closed := syscallextra.DiffFDInventory(before, after)
+	require.Empty(t, closed)
+	require.Len(t, opened, 3)
+
+	fi := opened[slices.IndexFunc(opened, func(i syscallextra.FDInfo) bool { return i.FD == int(f.Fd()) })]
+	require.Equal(t, path, fi.Target)
+	require.Equal(t, "file", fi.Type)
+	require.Equal(t, int64(3), fi.Pos)
+	require.Equal(t, unix.O_RDONLY, fi.Flags&unix.O_ACCMODE)
+	require.NotZero(t, fi.Flags&unix.O_CLOEXEC)
+
+	wi := opened[slices.IndexFunc(opened, func(i syscallextra.FDInfo) bool { return i.FD == int(w.Fd()) })]
+	require.Equal(t, "pipe", wi.Type)
+	require.Equal(t, unix.O_WRONLY, wi.Flags&unix.O_ACCMODE)
+
+	require.NoError(t, f.Close())
+	require.NoError(t, r.Close())
+	require.NoError(t, w.Close())
+
+	final, err := syscallextra.FDInventory()
+	require.NoError(t, err)
+	opened, closed = syscallextra.DiffFDInventory(after, final)
+	require.Empty(t, opened)
+	require.Len(t, closed, 3)
+}
+
+func TestRequireNoFDLeaks(t *testing.T) {
+	syscallextra.RequireNoFDLeaks(t)
+
+	f, err := os.Open(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+// Captures the errors of a test helper instead of failing the test
+type errorCapture struct {
+	testing.TB
+	mut    sync.Mutex
+	errors []string
+}
+
+func (e *errorCapture) Error(args ...any) {
+	e.mut.Lock()
+	defer e.mut.Unlock()
+	e.errors = append(e.errors, fmt.Sprint(args...))
+}
+
+func TestRequireNoFDLeaksFails(t *testing.T) {
+	capture := &errorCapture{}
+	var leaked *os.File
+	path := t.TempDir()
+	t.Run("leak", func(t *testing.T) {
+		capture.TB = t
+		syscallextra.RequireNoFDLeaks(capture)
+		var err error
+		leaked, err = os.Open(path)
+		require.NoError(t, err)
+	})
+	defer leaked.Close()
+
+	require.Len(t, capture.errors, 1)
+	require.Contains(t, capture.errors[0], "1 fd(s) leaked:")
+	require.Contains(t, capture.errors[0], fmt.Sprintf("\n\t+ fd %d: %s (dir)", leaked.Fd(), path))
+}
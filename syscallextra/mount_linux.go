@@ -0,0 +1,333 @@
+package syscallextra
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// One line of /proc/<pid>/mountinfo, see proc(5)
+type MountInfo struct {
+	ID           int
+	ParentID     int
+	Major, Minor uint32
+	Root         string   // Path within the filesystem that is the root of this mount
+	MountPoint   string   // Relative to the process's root
+	Options      []string // Per mount options
+	Optional     []string // Raw optional fields, like "shared:1"
+	FSType       string
+	Source       string
+	SuperOptions []string // Per superblock options
+
+	// Decoded from Optional
+	Shared        int // Peer group id, 0 if not shared
+	Master        int // Peer group this mount is a slave of, 0 if not a slave
+	PropagateFrom int // Nearest dominant peer group, 0 if not reported
+	Unbindable    bool
+}
+
+// "6:0" or similar, in the same format as DeviceMajorMinorFromMountPath
+func (m MountInfo) Device() string {
+	return fmt.Sprintf("%d:%d", m.Major, m.Minor)
+}
+
+// Parses /proc/self/mountinfo
+func SelfMountInfo() ([]MountInfo, error) {
+	return ReadMountInfo("/proc/self/mountinfo")
+}
+
+// Parses a mountinfo file
+func ReadMountInfo(path string) ([]MountInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mounts, err := ParseMountInfo(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return mounts, nil
+}
+
+// Parses mountinfo formatted lines, like:
+// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+func ParseMountInfo(r io.Reader) ([]MountInfo, error) {
+	mounts := []MountInfo{}
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+		m, err := parseMountInfoLine(s.Text())
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, s.Err()
+}
+
+func parseMountInfoLine(line string) (MountInfo, error) {
+	m := MountInfo{}
+	fields := strings.Split(line, " ")
+	malformed := func(reason string) error {
+		return fmt.Errorf("malformed mountinfo line (%s): %+q", reason, line)
+	}
+
+	sep := -1
+	for i := 6; i < len(fields); i++ {
+		if fields[i] == "-" {
+			sep = i
+			break
+		}
+	}
+	if sep < 0 || len(fields) < sep+4 {
+		return m, malformed("missing fields")
+	}
+
+	var err error
+	if m.ID, err = strconv.Atoi(fields[0]); err != nil {
+		return m, malformed("mount id")
+	}
+	if m.ParentID, err = strconv.Atoi(fields[1]); err != nil {
+		return m, malformed("parent id")
+	}
+	major, minor, ok := strings.Cut(fields[2], ":")
+	if !ok {
+		return m, malformed("major:minor")
+	}
+	if v, err := strconv.ParseUint(major, 10, 32); err != nil {
+		return m, malformed("major")
+	} else {
+		m.Major = uint32(v)
+	}
+	if v, err := strconv.ParseUint(minor, 10, 32); err != nil {
+		return m, malformed("minor")
+	} else {
+		m.Minor = uint32(v)
+	}
+
+	m.Root = UnescapeMountInfo(fields[3])
+	m.MountPoint = UnescapeMountInfo(fields[4])
+	m.Options = strings.Split(fields[5], ",")
+
+	m.Optional = []string{}
+	for _, opt := range fields[6:sep] {
+		m.Optional = append(m.Optional, opt)
+		tag, value, _ := strings.Cut(opt, ":")
+		var target *int
+		switch tag {
+		case "shared":
+			target = &m.Shared
+		case "master":
+			target = &m.Master
+		case "propagate_from":
+			target = &m.PropagateFrom
+		case "unbindable":
+			m.Unbindable = true
+			continue
+		default:
+			// Unknown fields should be ignored, per proc(5)
+			continue
+		}
+		if *target, err = strconv.Atoi(value); err != nil {
+			return m, malformed(tag)
+		}
+	}
+
+	m.FSType = UnescapeMountInfo(fields[sep+1])
+	m.Source = UnescapeMountInfo(fields[sep+2])
+	m.SuperOptions = strings.Split(fields[sep+3], ",")
+	return m, nil
+}
+
+// The kernel escapes space, tab, newline and backslash in mountinfo paths as
+// octal (\040), this reverses that
+func UnescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	b := strings.Builder{}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if v, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(v))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Calls mount(2)
+func Mount(source, target, fstype string, flags uintptr, data string) error {
+	if err := WrapEINTR(func() error {
+		return unix.Mount(source, target, fstype, flags, data)
+	}); err != nil {
+		return &os.PathError{Op: "mount", Path: target, Err: err}
+	}
+	return nil
+}
+
+// Calls umount2(2)
+func Unmount(target string, flags int) error {
+	if err := WrapEINTR(func() error {
+		return unix.Unmount(target, flags)
+	}); err != nil {
+		return &os.PathError{Op: "umount", Path: target, Err: err}
+	}
+	return nil
+}
+
+// A filesystem context from fsopen(2), configure it with the Set methods,
+// then create a detached mount with Mount
+type FSContext struct {
+	fd     int
+	fsType string
+}
+
+// Starts configuring a new instance of the filesystem fsType, like "tmpfs" or "cgroup2"
+func FSOpen(fsType string) (*FSContext, error) {
+	var fd int
+	if err := WrapEINTR(func() (err error) {
+		fd, err = unix.Fsopen(fsType, unix.FSOPEN_CLOEXEC)
+		return
+	}); err != nil {
+		return nil, &os.PathError{Op: "fsopen", Path: fsType, Err: err}
+	}
+	return &FSContext{fd: fd, fsType: fsType}, nil
+}
+
+// Closes the context, detached mounts made from it remain valid
+func (c *FSContext) Close() error {
+	if c.fd < 0 {
+		panic("fs context closed")
+	}
+	err := unix.Close(c.fd)
+	c.fd = -1
+	return err
+}
+
+// Sets a string option, like "size"="1M" for tmpfs
+func (c *FSContext) SetString(key, value string) error {
+	return c.config("fsconfig "+key, func() error {
+		return unix.FsconfigSetString(c.fd, key, value)
+	})
+}
+
+// Sets a flag option, like "ro" or "nsdelegate"
+func (c *FSContext) SetFlag(key string) error {
+	return c.config("fsconfig "+key, func() error {
+		return unix.FsconfigSetFlag(c.fd, key)
+	})
+}
+
+// Creates the superblock and returns a detached mount for it, not attached
+// to any path until it is moved with MoveMount.  The mount can still be used
+// as a directory, and is cleaned up when the file is closed if not attached
+// attrs are MOUNT_ATTR_* flags
+func (c *FSContext) Mount(attrs int) (*os.File, error) {
+	if err := c.config("fsconfig create", func() error {
+		return unix.FsconfigCreate(c.fd)
+	}); err != nil {
+		return nil, err
+	}
+	var fd int
+	if err := c.config("fsmount", func() (err error) {
+		fd, err = unix.Fsmount(c.fd, unix.FSMOUNT_CLOEXEC, attrs)
+		return
+	}); err != nil {
+		return nil, err
+	}
+	return os.NewFile(uintptr(fd), "detached "+c.fsType), nil
+}
+
+// Runs a fsconfig style call, on failure the kernel may have queued messages
+// that explain the failure, those are added to the error
+func (c *FSContext) config(op string, call func() error) error {
+	if c.fd < 0 {
+		panic("fs context closed")
+	}
+	err := WrapEINTR(call)
+	if err == nil {
+		return nil
+	}
+	if msgs := c.kernelMessages(); len(msgs) > 0 {
+		return &os.PathError{Op: op, Path: c.fsType,
+			Err: fmt.Errorf("%w (%s)", err, strings.Join(msgs, "; "))}
+	}
+	return &os.PathError{Op: op, Path: c.fsType, Err: err}
+}
+
+// Reads the queued error/warning messages from the context
+func (c *FSContext) kernelMessages() []string {
+	msgs := []string{}
+	buf := make([]byte, 4096)
+	for {
+		n, err := unix.Read(c.fd, buf)
+		if errors.Is(err, unix.EINTR) {
+			continue
+		}
+		if err != nil || n <= 0 {
+			return msgs
+		}
+		msgs = append(msgs, strings.TrimSpace(string(buf[:n])))
+	}
+}
+
+// Detached mount of a new filesystem instance of fsType, options are set
+// with SetString, or SetFlag when the value is empty
+func MountDetached(fsType string, options map[string]string, attrs int) (*os.File, error) {
+	c, err := FSOpen(fsType)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+	for k, v := range options {
+		if v == "" {
+			err = c.SetFlag(k)
+		} else {
+			err = c.SetString(k, v)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.Mount(attrs)
+}
+
+// Calls open_tree(2), with OPEN_TREE_CLONE this makes a detached bind mount
+// of path.  AT_RECURSIVE includes submounts.  OPEN_TREE_CLOEXEC is always added
+// The returned file is an O_PATH fd, so it can't be read directly, but it
+// can be used with MoveMount or as a path via /proc/self/fd
+func OpenTree(dirfd int, path string, flags uint) (*os.File, error) {
+	var fd int
+	if err := WrapEINTR(func() (err error) {
+		fd, err = unix.OpenTree(dirfd, path, flags|unix.OPEN_TREE_CLOEXEC)
+		return
+	}); err != nil {
+		return nil, &os.PathError{Op: "open_tree", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// Attaches a detached mount (from FSContext.Mount or OpenTree) at target
+func MoveMount(mnt *os.File, target string) error {
+	if err := WrapEINTR(func() error {
+		return unix.MoveMount(int(mnt.Fd()), "", unix.AT_FDCWD, target, unix.MOVE_MOUNT_F_EMPTY_PATH)
+	}); err != nil {
+		return &os.PathError{Op: "move_mount", Path: target, Err: err}
+	}
+	runtime.KeepAlive(mnt)
+	return nil
+}
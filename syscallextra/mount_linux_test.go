@@ -0,0 +1,105 @@
+package syscallextra_test
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/syscallextra"
+	"golang.org/x/sys/unix"
+)
+
+func TestParseMountInfo(t *testing.T) {
+	mounts, err := syscallextra.ParseMountInfo(strings.NewReader(
+		"36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue\n" +
+			"24 1 254:1 / / rw,relatime shared:1 propagate_from:3 unbindable - ext4 /dev/vda1 rw\n" +
+			`621 612 0:58 /jobs /mnt/job\040cgroups rw - cgroup2 cgroup rw` + "\n"))
+	require.NoError(t, err)
+	require.Equal(t, []syscallextra.MountInfo{
+		{
+			ID: 36, ParentID: 35, Major: 98, Minor: 0,
+			Root: "/mnt1", MountPoint: "/mnt2",
+			Options:  []string{"rw", "noatime"},
+			Optional: []string{"master:1"},
+			FSType:   "ext3", Source: "/dev/root",
+			SuperOptions: []string{"rw", "errors=continue"},
+			Master:       1,
+		},
+		{
+			ID: 24, ParentID: 1, Major: 254, Minor: 1,
+			Root: "/", MountPoint: "/",
+			Options:  []string{"rw", "relatime"},
+			Optional: []string{"shared:1", "propagate_from:3", "unbindable"},
+			FSType:   "ext4", Source: "/dev/vda1",
+			SuperOptions:  []string{"rw"},
+			Shared:        1,
+			PropagateFrom: 3,
+			Unbindable:    true,
+		},
+		{
+			ID: 621, ParentID: 612, Major: 0, Minor: 58,
+			Root: "/jobs", MountPoint: "/mnt/job cgroups",
+			Options:  []string{"rw"},
+			Optional: []string{},
+			FSType:   "cgroup2", Source: "cgroup",
+			SuperOptions: []string{"rw"},
+		},
+	}, mounts)
+	require.Equal(t, "98:0", mounts[0].Device())
+
+	_, err = syscallextra.ParseMountInfo(strings.NewReader("36 35 98:0 /mnt1 /mnt2 rw\n"))
+	require.ErrorContains(t, err, "malformed mountinfo line")
+}
+
+func TestSelfMountInfoRoot(t *testing.T) {
+	mounts, err := syscallextra.SelfMountInfo()
+	require.NoError(t, err)
+
+	d, err := syscallextra.DeviceMajorMinorFromMountPath("/")
+	require.NoError(t, err)
+	require.True(t, slices.ContainsFunc(mounts, func(m syscallextra.MountInfo) bool {
+		return m.MountPoint == "/" && m.Device() == d
+	}))
+}
+
+func TestMountDetached(t *testing.T) {
+	mnt, err := syscallextra.MountDetached("tmpfs", map[string]string{"size": "1M"}, 0)
+	if err != nil {
+		t.Skipf("can't use the new mount API here: %s", err)
+	}
+
+	// Usable as a directory without attaching it anywhere
+	detachedPath := fmt.Sprintf("/proc/self/fd/%d", mnt.Fd())
+	require.NoError(t, os.WriteFile(detachedPath+"/hello", []byte("world"), 0600))
+
+	target := t.TempDir()
+	require.NoError(t, syscallextra.MoveMount(mnt, target))
+	// The fd holds a reference to the mount, which would make umount fail
+	require.NoError(t, mnt.Close())
+	defer func() { require.NoError(t, syscallextra.Unmount(target, 0)) }()
+	content, err := os.ReadFile(target + "/hello")
+	require.NoError(t, err)
+	require.Equal(t, "world", string(content))
+
+	// Bind it somewhere else too
+	clone, err := syscallextra.OpenTree(unix.AT_FDCWD, target, unix.OPEN_TREE_CLONE)
+	require.NoError(t, err)
+	defer clone.Close()
+	content, err = os.ReadFile(fmt.Sprintf("/proc/self/fd/%d/hello", clone.Fd()))
+	require.NoError(t, err)
+	require.Equal(t, "world", string(content))
+}
+
+func TestFSContextErrors(t *testing.T) {
+	c, err := syscallextra.FSOpen("tmpfs")
+	if err != nil {
+		t.Skipf("can't use the new mount API here: %s", err)
+	}
+	defer c.Close()
+	err = c.SetString("size", "not-a-size")
+	require.ErrorIs(t, err, unix.EINVAL)
+	require.ErrorContains(t, err, "fsconfig size")
+}
@@ -0,0 +1,306 @@
+package syscallextra
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setns(2) nstype for each entry in /proc/<pid>/ns
+var namespaceFlags = map[string]int{
+	"cgroup":            unix.CLONE_NEWCGROUP,
+	"ipc":               unix.CLONE_NEWIPC,
+	"mnt":               unix.CLONE_NEWNS,
+	"net":               unix.CLONE_NEWNET,
+	"pid":               unix.CLONE_NEWPID,
+	"pid_for_children":  unix.CLONE_NEWPID,
+	"time":              unix.CLONE_NEWTIME,
+	"time_for_children": unix.CLONE_NEWTIME,
+	"user":              unix.CLONE_NEWUSER,
+	"uts":               unix.CLONE_NEWUTS,
+}
+
+// An open namespace fd, from /proc/<pid>/ns/<type>
+type Namespace struct {
+	f    *os.File
+	Type string // "net", "mnt", "uts"... as named in /proc/<pid>/ns
+}
+
+// Identifies a namespace, two fds refer to the same namespace iff their IDs are equal
+type NamespaceID struct {
+	DevMajor, DevMinor uint32
+	Ino                uint64
+}
+
+// Like "[4026531833] on 0:4", the inode is what the /proc/<pid>/ns symlinks show
+func (id NamespaceID) String() string {
+	return fmt.Sprintf("[%d] on %d:%d", id.Ino, id.DevMajor, id.DevMinor)
+}
+
+// Opens /proc/<pid>/ns/<nsType>, pid 0 means the calling thread, which is
+// what matters after setns, since namespaces are per thread
+func OpenNamespace(pid int, nsType string) (*Namespace, error) {
+	if _, ok := namespaceFlags[nsType]; !ok {
+		return nil, fmt.Errorf("unknown namespace type: %+q", nsType)
+	}
+	path := fmt.Sprintf("/proc/%d/ns/%s", pid, nsType)
+	if pid == 0 {
+		path = "/proc/thread-self/ns/" + nsType
+	}
+	f, err := os.OpenFile(path, unix.O_RDONLY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &Namespace{f: f, Type: nsType}, nil
+}
+
+// The raw namespace fd
+func (n *Namespace) Fd() int {
+	return int(n.f.Fd())
+}
+
+// Closes the namespace fd
+func (n *Namespace) Close() error {
+	return n.f.Close()
+}
+
+// The identity of the namespace, from statx on the fd
+func (n *Namespace) ID() (NamespaceID, error) {
+	st, err := StatxFD(n.Fd(), StatxOptions{Mask: unix.STATX_INO})
+	if err != nil {
+		return NamespaceID{}, err
+	}
+	return NamespaceID{DevMajor: st.DevMajor, DevMinor: st.DevMinor, Ino: st.Ino}, nil
+}
+
+// Whether a and b are the same namespace
+func SameNamespace(a, b *Namespace) (bool, error) {
+	aID, err := a.ID()
+	if err != nil {
+		return false, err
+	}
+	bID, err := b.ID()
+	if err != nil {
+		return false, err
+	}
+	return aID == bID, nil
+}
+
+// Runs fn on a new goroutine locked to an OS thread that has joined ns with
+// setns(2), then moves the thread back to its original namespace
+// Only fn's thread is in the namespace, goroutines it starts are not, but
+// processes it spawns are (for "pid", only those are in it)
+// If the thread can't be restored it is never unlocked, so the runtime
+// discards it.  That is always the case for "mnt", which needs the thread to
+// stop sharing its cwd and root with the rest of the process first
+// "user" is not supported, the kernel refuses it for threaded processes
+func RunInNamespace(ns *Namespace, fn func() error) error {
+	flag := namespaceFlags[ns.Type]
+	if flag == unix.CLONE_NEWUSER {
+		return fmt.Errorf("setns: can't join a user namespace from a multithreaded process")
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		restored := false
+		defer func() {
+			if restored {
+				runtime.UnlockOSThread()
+			}
+		}()
+
+		if flag == unix.CLONE_NEWNS {
+			if err := unix.Unshare(unix.CLONE_FS); err != nil {
+				errc <- os.NewSyscallError("unshare", err)
+				return
+			}
+		}
+
+		orig, err := OpenNamespace(0, ns.Type)
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer orig.Close()
+
+		if err := setns(ns.Fd(), flag); err != nil {
+			restored = flag != unix.CLONE_NEWNS
+			errc <- err
+			return
+		}
+		fnErr := fn()
+		if flag != unix.CLONE_NEWNS {
+			if err := setns(orig.Fd(), flag); err != nil {
+				fnErr = errors.Join(fnErr, fmt.Errorf("restoring %s namespace: %w", ns.Type, err))
+			} else {
+				restored = true
+			}
+		}
+		errc <- fnErr
+	}()
+	return <-errc
+}
+
+// Converts namespace types, as named in /proc/<pid>/ns, to CLONE_NEW* flags
+// for UnshareInThread, or SysProcAttr.Cloneflags and Unshareflags
+func NamespaceFlags(nsTypes ...string) (int, error) {
+	flags := 0
+	for _, nsType := range nsTypes {
+		flag, ok := namespaceFlags[nsType]
+		if !ok {
+			return 0, fmt.Errorf("unknown namespace type: %+q", nsType)
+		}
+		flags |= flag
+	}
+	return flags, nil
+}
+
+// Runs fn on a new goroutine locked to an OS thread that has moved into new
+// namespaces with unshare(2), flags are CLONE_NEW* flags
+// The thread is never unlocked, so the runtime discards it when fn returns
+// Like RunInNamespace, only fn's thread and processes it spawns are in the
+// new namespaces, and CLONE_NEWNS also unshares CLONE_FS
+// CLONE_NEWUSER is not supported, the kernel refuses it for threaded
+// processes, use SysProcAttr.Cloneflags to start a child in one instead
+func UnshareInThread(flags int, fn func() error) error {
+	if flags&unix.CLONE_NEWUSER != 0 {
+		return fmt.Errorf("unshare: can't create a user namespace from a multithreaded process")
+	}
+	if flags&unix.CLONE_NEWNS != 0 {
+		flags |= unix.CLONE_FS
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		runtime.LockOSThread()
+		if err := unix.Unshare(flags); err != nil {
+			errc <- os.NewSyscallError("unshare", err)
+			return
+		}
+		errc <- fn()
+	}()
+	return <-errc
+}
+
+func setns(fd int, flag int) error {
+	return os.NewSyscallError("setns", WrapEINTR(func() error {
+		return unix.Setns(fd, flag)
+	}))
+}
+
+// One line of /proc/<pid>/uid_map or gid_map, see user_namespaces(7)
+type IDMap struct {
+	InsideID  uint32 // First id in the namespace
+	OutsideID uint32 // First id in the parent namespace
+	Count     uint32
+}
+
+// Formats maps the way uid_map and gid_map expect them
+func FormatIDMaps(maps []IDMap) string {
+	b := strings.Builder{}
+	for _, m := range maps {
+		fmt.Fprintf(&b, "%d %d %d\n", m.InsideID, m.OutsideID, m.Count)
+	}
+	return b.String()
+}
+
+// Parses the contents of uid_map or gid_map
+func ParseIDMaps(s string) ([]IDMap, error) {
+	maps := []IDMap{}
+	for _, line := range strings.Split(s, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("malformed id map line: %+q", line)
+		}
+		vals := [3]uint32{}
+		for i, f := range fields {
+			v, err := strconv.ParseUint(f, 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("malformed id map line: %+q", line)
+			}
+			vals[i] = uint32(v)
+		}
+		maps = append(maps, IDMap{InsideID: vals[0], OutsideID: vals[1], Count: vals[2]})
+	}
+	return maps, nil
+}
+
+// Reads /proc/<pid>/uid_map and gid_map
+func ReadIDMaps(pid int) (uids, gids []IDMap, err error) {
+	read := func(name string) ([]IDMap, error) {
+		path := fmt.Sprintf("/proc/%d/%s", pid, name)
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		maps, err := ParseIDMaps(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return maps, nil
+	}
+	if uids, err = read("uid_map"); err != nil {
+		return nil, nil, err
+	}
+	if gids, err = read("gid_map"); err != nil {
+		return nil, nil, err
+	}
+	return uids, gids, nil
+}
+
+// Sets up the id maps of a process that was started in a new user
+// namespace, each map can only be written once.  An unprivileged writer has
+// to deny setgroups before it is allowed to write gids, and can only map
+// its own ids, see RootlessIDMaps
+func WriteIDMaps(pid int, uids, gids []IDMap, denySetgroups bool) error {
+	write := func(name, content string) error {
+		return os.WriteFile(fmt.Sprintf("/proc/%d/%s", pid, name), []byte(content), 0)
+	}
+	if denySetgroups {
+		if err := write("setgroups", "deny"); err != nil {
+			return err
+		}
+	}
+	if len(uids) > 0 {
+		if err := write("uid_map", FormatIDMaps(uids)); err != nil {
+			return err
+		}
+	}
+	if len(gids) > 0 {
+		if err := write("gid_map", FormatIDMaps(gids)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Maps root in the namespace to our effective uid and gid, which is all an
+// unprivileged process is allowed to map
+func RootlessIDMaps() (uids, gids []IDMap) {
+	return []IDMap{{InsideID: 0, OutsideID: uint32(os.Geteuid()), Count: 1}},
+		[]IDMap{{InsideID: 0, OutsideID: uint32(os.Getegid()), Count: 1}}
+}
+
+// Converts maps for SysProcAttr.UidMappings and GidMappings, os/exec then
+// writes them for the child before it runs
+func SysProcIDMaps(maps []IDMap) []syscall.SysProcIDMap {
+	out := []syscall.SysProcIDMap{}
+	for _, m := range maps {
+		out = append(out, syscall.SysProcIDMap{
+			ContainerID: int(m.InsideID),
+			HostID:      int(m.OutsideID),
+			Size:        int(m.Count),
+		})
+	}
+	return out
+}
@@ -0,0 +1,173 @@
+package syscallextra_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/syscallextra"
+	"golang.org/x/sys/unix"
+)
+
+func openNamespace(t *testing.T, pid int, nsType string) *syscallextra.Namespace {
+	t.Helper()
+	ns, err := syscallextra.OpenNamespace(pid, nsType)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, ns.Close()) })
+	return ns
+}
+
+func TestNamespaceIdentity(t *testing.T) {
+	syscallextra.RequireNoFDLeaks(t)
+
+	same, err := syscallextra.SameNamespace(openNamespace(t, os.Getpid(), "net"), openNamespace(t, 0, "net"))
+	require.NoError(t, err)
+	require.True(t, same)
+
+	same, err = syscallextra.SameNamespace(openNamespace(t, 0, "net"), openNamespace(t, 0, "uts"))
+	require.NoError(t, err)
+	require.False(t, same)
+
+	_, err = syscallextra.OpenNamespace(0, "bogus")
+	require.Error(t, err)
+}
+
+// Starts a child in new user and uts namespaces, mapped with RootlessIDMaps
+func startInNamespaces(t *testing.T) *exec.Cmd {
+	t.Helper()
+	uids, gids := syscallextra.RootlessIDMaps()
+	cmd := exec.Command("sleep", "1000")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWUTS,
+		UidMappings: syscallextra.SysProcIDMaps(uids),
+		GidMappings: syscallextra.SysProcIDMaps(gids),
+	}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("can't create user namespaces here: %s", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	return cmd
+}
+
+func TestRunInNamespace(t *testing.T) {
+	cmd := startInNamespaces(t)
+	childUTS := openNamespace(t, cmd.Process.Pid, "uts")
+	ourUTS := openNamespace(t, 0, "uts")
+	childID, err := childUTS.ID()
+	require.NoError(t, err)
+	ourID, err := ourUTS.ID()
+	require.NoError(t, err)
+	require.NotEqual(t, ourID, childID)
+
+	insideID := syscallextra.NamespaceID{}
+	// fn runs on another goroutine, so it reports back instead of using require
+	err = syscallextra.RunInNamespace(childUTS, func() error {
+		inside, err := syscallextra.OpenNamespace(0, "uts")
+		if err != nil {
+			return err
+		}
+		defer inside.Close()
+		insideID, err = inside.ID()
+		return err
+	})
+	if errors.Is(err, unix.EPERM) {
+		t.Skipf("not allowed to join the namespace: %s", err)
+	}
+	require.NoError(t, err)
+	require.Equal(t, childID, insideID)
+
+	// The calling thread was never moved
+	same, err := syscallextra.SameNamespace(ourUTS, openNamespace(t, 0, "uts"))
+	require.NoError(t, err)
+	require.True(t, same)
+
+	require.Error(t, syscallextra.RunInNamespace(openNamespace(t, cmd.Process.Pid, "user"), func() error { return nil }))
+}
+
+func TestUnshareInThread(t *testing.T) {
+	ourUTS := openNamespace(t, 0, "uts")
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	flags, err := syscallextra.NamespaceFlags("uts")
+	require.NoError(t, err)
+	same := true
+	// fn runs on another goroutine, so it reports back instead of using require
+	err = syscallextra.UnshareInThread(flags, func() error {
+		inside, err := syscallextra.OpenNamespace(0, "uts")
+		if err != nil {
+			return err
+		}
+		defer inside.Close()
+		if same, err = syscallextra.SameNamespace(ourUTS, inside); err != nil {
+			return err
+		}
+		return unix.Sethostname([]byte("unshared"))
+	})
+	if errors.Is(err, unix.EPERM) {
+		t.Skipf("not allowed to unshare: %s", err)
+	}
+	require.NoError(t, err)
+	require.False(t, same)
+
+	// Nothing outside of fn's thread was affected
+	same, err = syscallextra.SameNamespace(ourUTS, openNamespace(t, 0, "uts"))
+	require.NoError(t, err)
+	require.True(t, same)
+	after, err := os.Hostname()
+	require.NoError(t, err)
+	require.Equal(t, hostname, after)
+
+	flags, err = syscallextra.NamespaceFlags("user", "net")
+	require.NoError(t, err)
+	require.Equal(t, unix.CLONE_NEWUSER|unix.CLONE_NEWNET, flags)
+	require.Error(t, syscallextra.UnshareInThread(flags, func() error { return nil }))
+	_, err = syscallextra.NamespaceFlags("bogus")
+	require.Error(t, err)
+}
+
+func TestIDMaps(t *testing.T) {
+	maps, err := syscallextra.ParseIDMaps("         0       1000          1\n  1 100000 65536\n")
+	require.NoError(t, err)
+	require.Equal(t, []syscallextra.IDMap{
+		{InsideID: 0, OutsideID: 1000, Count: 1},
+		{InsideID: 1, OutsideID: 100000, Count: 65536},
+	}, maps)
+	require.Equal(t, "0 1000 1\n1 100000 65536\n", syscallextra.FormatIDMaps(maps))
+
+	_, err = syscallextra.ParseIDMaps("0 1000\n")
+	require.Error(t, err)
+
+	cmd := startInNamespaces(t)
+	uids, gids, err := syscallextra.ReadIDMaps(cmd.Process.Pid)
+	require.NoError(t, err)
+	wantUIDs, wantGIDs := syscallextra.RootlessIDMaps()
+	require.Equal(t, wantUIDs, uids)
+	require.Equal(t, wantGIDs, gids)
+}
+
+func TestWriteIDMaps(t *testing.T) {
+	cmd := exec.Command("sleep", "1000")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWUSER}
+	if err := cmd.Start(); err != nil {
+		t.Skipf("can't create user namespaces here: %s", err)
+	}
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	uids, gids := syscallextra.RootlessIDMaps()
+	require.NoError(t, syscallextra.WriteIDMaps(cmd.Process.Pid, uids, gids, true))
+	gotUIDs, gotGIDs, err := syscallextra.ReadIDMaps(cmd.Process.Pid)
+	require.NoError(t, err)
+	require.Equal(t, uids, gotUIDs)
+	require.Equal(t, gids, gotGIDs)
+
+	// Maps can only be written once
+	require.Error(t, syscallextra.WriteIDMaps(cmd.Process.Pid, uids, nil, false))
+}
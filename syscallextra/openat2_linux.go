@@ -0,0 +1,167 @@
+package syscallextra
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// RESOLVE_CACHED (Linux 5.12), golang.org/x/sys/unix doesn't have it yet
+const ResolveCached = 0x20
+
+// How many times Openat2 tries when racing with renames and mounts
+const openat2Attempts = 16
+
+// Calls openat2, which lets the caller restrict how path is resolved with
+// how.Resolve: RESOLVE_BENEATH, RESOLVE_IN_ROOT, RESOLVE_NO_SYMLINKS,
+// RESOLVE_NO_MAGICLINKS, RESOLVE_NO_XDEV...
+// O_CLOEXEC is always added.  Retries a few times when the kernel reports
+// EAGAIN, which happens when a concurrent rename or mount could have made the
+// resolution unsafe.  With RESOLVE_CACHED, EAGAIN means the lookup wasn't
+// cached and is returned straight away, so the caller can fall back
+func Openat2(dirfd int, path string, how unix.OpenHow) (*os.File, error) {
+	how.Flags |= unix.O_CLOEXEC
+	var fd int
+	err := WrapEINTR(func() (err error) {
+		for range openat2Attempts {
+			fd, err = unix.Openat2(dirfd, path, &how)
+			if !errors.Is(err, unix.EAGAIN) || how.Resolve&ResolveCached != 0 {
+				return err
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, &os.PathError{Op: "openat2", Path: path, Err: err}
+	}
+	return os.NewFile(uintptr(fd), path), nil
+}
+
+// Resolve flags used by OpenRootedFS when 0 is given: symlinks are resolved
+// as if the directory was the root, and /proc style magic links are refused
+const DefaultRootedResolve = unix.RESOLVE_IN_ROOT | unix.RESOLVE_NO_MAGICLINKS
+
+// An fs.FS where every lookup is done with openat2 relative to a directory
+// fd, so untrusted content (symlinks, "..") can't escape the directory
+type RootedFS struct {
+	dir     *os.File
+	resolve uint64
+}
+
+// Opens dirPath as the root of a RootedFS, resolve is RESOLVE_* flags
+// (0 means DefaultRootedResolve).  Caller must Close it
+func OpenRootedFS(dirPath string, resolve uint64) (*RootedFS, error) {
+	dir, err := os.OpenFile(dirPath, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resolve == 0 {
+		resolve = DefaultRootedResolve
+	}
+	return &RootedFS{dir: dir, resolve: resolve}, nil
+}
+
+// Closes the directory fd
+func (r *RootedFS) Close() error {
+	return r.dir.Close()
+}
+
+// Implements fs.FS, opens name read only
+func (r *RootedFS) Open(name string) (fs.File, error) {
+	f, err := r.OpenFile(name, unix.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return rootedFile{f}, nil
+}
+
+// Like os.OpenFile, but confined to the root
+// name must be a valid fs.FS path (see fs.ValidPath)
+// NOTE: ReadDir on the returned file resolves DirEntry.Info by path, use
+// Open to get a file that keeps those lookups confined too
+func (r *RootedFS) OpenFile(name string, flags int, mode fs.FileMode) (*os.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	f, err := Openat2(int(r.dir.Fd()), name, unix.OpenHow{
+		Flags:   uint64(flags),
+		Mode:    uint64(mode.Perm()),
+		Resolve: r.resolve,
+	})
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.Unwrap(err)}
+	}
+	return f, nil
+}
+
+// Implements fs.StatFS
+func (r *RootedFS) Stat(name string) (fs.FileInfo, error) {
+	f, err := r.OpenFile(name, unix.O_PATH, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
+
+// Implements fs.ReadFileFS
+func (r *RootedFS) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(fs.FS(rootedFSNoReadFile{r}), name)
+}
+
+// Implements fs.ReadDirFS
+func (r *RootedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(fs.FS(rootedFSNoReadFile{r}), name)
+}
+
+// Hides the RootedFS methods, so the fs helpers fall back to using Open
+type rootedFSNoReadFile struct {
+	r *RootedFS
+}
+
+func (n rootedFSNoReadFile) Open(name string) (fs.File, error) {
+	return n.r.Open(name)
+}
+
+// Directory entries from os.File.ReadDir lstat their path when Info is
+// called, this does those lookups relative to the directory fd instead
+type rootedFile struct {
+	*os.File
+}
+
+func (f rootedFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	entries, err := f.File.ReadDir(n)
+	for i, e := range entries {
+		entries[i] = rootedDirEntry{DirEntry: e, dir: f.File}
+	}
+	return entries, err
+}
+
+type rootedDirEntry struct {
+	fs.DirEntry
+	dir *os.File
+}
+
+func (e rootedDirEntry) Info() (fs.FileInfo, error) {
+	st, err := StatxAt(int(e.dir.Fd()), e.Name(), StatxOptions{Flags: unix.AT_SYMLINK_NOFOLLOW})
+	if err != nil {
+		return nil, err
+	}
+	return statxFileInfo{name: e.Name(), st: st}, nil
+}
+
+// Implements fs.FileInfo, Sys returns *unix.Statx_t
+type statxFileInfo struct {
+	name string
+	st   StatxResult
+}
+
+func (i statxFileInfo) Name() string       { return i.name }
+func (i statxFileInfo) Size() int64        { return int64(i.st.Size) }
+func (i statxFileInfo) Mode() fs.FileMode  { return i.st.Mode }
+func (i statxFileInfo) ModTime() time.Time { return i.st.Mtime }
+func (i statxFileInfo) IsDir() bool        { return i.st.Mode.IsDir() }
+func (i statxFileInfo) Sys() any           { return &i.st.Raw }
@@ -0,0 +1,114 @@
+package syscallextra_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/syscallextra"
+	"golang.org/x/sys/unix"
+)
+
+// root/
+//
+//	dir/b         "inside b"
+//	a             "inside a"
+//	escape     -> ../outside
+//	absolute   -> /a
+//
+// outside         "outside"
+func makeRootedTree(t *testing.T) string {
+	base := t.TempDir()
+	root := base + "/root"
+	require.NoError(t, os.MkdirAll(root+"/dir", 0755))
+	require.NoError(t, os.WriteFile(root+"/a", []byte("inside a"), 0644))
+	require.NoError(t, os.WriteFile(root+"/dir/b", []byte("inside b"), 0644))
+	require.NoError(t, os.WriteFile(base+"/outside", []byte("outside"), 0644))
+	require.NoError(t, os.Symlink("../outside", root+"/escape"))
+	require.NoError(t, os.Symlink("/a", root+"/absolute"))
+	return root
+}
+
+func TestOpenat2Resolve(t *testing.T) {
+	root := makeRootedTree(t)
+	dir, err := os.Open(root)
+	require.NoError(t, err)
+	defer dir.Close()
+	dirfd := int(dir.Fd())
+
+	open := func(path string, resolve uint64) error {
+		f, err := syscallextra.Openat2(dirfd, path, unix.OpenHow{Flags: unix.O_RDONLY, Resolve: resolve})
+		if err == nil {
+			f.Close()
+		}
+		return err
+	}
+
+	require.NoError(t, open("escape", 0))
+	require.ErrorIs(t, open("escape", unix.RESOLVE_BENEATH), unix.EXDEV)
+	require.ErrorIs(t, open("../outside", unix.RESOLVE_BENEATH), unix.EXDEV)
+	require.NoError(t, open("dir/../a", unix.RESOLVE_NO_SYMLINKS))
+	require.ErrorIs(t, open("absolute", unix.RESOLVE_NO_SYMLINKS), unix.ELOOP)
+	require.ErrorIs(t, open("/proc/self/exe", unix.RESOLVE_NO_MAGICLINKS), unix.ELOOP)
+
+	// In root, the absolute symlink resolves inside of the root
+	f, err := syscallextra.Openat2(dirfd, "absolute", unix.OpenHow{Resolve: unix.RESOLVE_IN_ROOT})
+	require.NoError(t, err)
+	defer f.Close()
+	got, err := syscallextra.DescribeOpenFD(int(f.Fd()))
+	require.NoError(t, err)
+	require.Equal(t, root+"/a", got)
+}
+
+// RESOLVE_CACHED refuses anything that would need I/O, like O_CREAT, with
+// EAGAIN, which must not be retried
+func TestOpenat2Cached(t *testing.T) {
+	dir, err := os.Open(t.TempDir())
+	require.NoError(t, err)
+	defer dir.Close()
+
+	_, err = syscallextra.Openat2(int(dir.Fd()), "new", unix.OpenHow{
+		Flags: unix.O_WRONLY | unix.O_CREAT, Mode: 0644, Resolve: syscallextra.ResolveCached,
+	})
+	if errors.Is(err, unix.EINVAL) {
+		t.Skip("RESOLVE_CACHED needs Linux 5.12")
+	}
+	require.ErrorIs(t, err, unix.EAGAIN)
+}
+
+func TestRootedFS(t *testing.T) {
+	root := makeRootedTree(t)
+	require.NoError(t, os.Remove(root+"/escape")) // fstest doesn't like dangling links
+
+	rfs, err := syscallextra.OpenRootedFS(root, 0)
+	require.NoError(t, err)
+	defer rfs.Close()
+
+	require.NoError(t, fstest.TestFS(rfs, "a", "dir/b", "absolute"))
+
+	content, err := rfs.ReadFile("absolute")
+	require.NoError(t, err)
+	require.Equal(t, "inside a", string(content))
+
+	_, err = rfs.Open("../outside")
+	require.ErrorIs(t, err, fs.ErrInvalid)
+}
+
+func TestRootedFSBeneath(t *testing.T) {
+	root := makeRootedTree(t)
+
+	rfs, err := syscallextra.OpenRootedFS(root, unix.RESOLVE_BENEATH)
+	require.NoError(t, err)
+	defer rfs.Close()
+
+	_, err = rfs.ReadFile("escape")
+	require.ErrorIs(t, err, unix.EXDEV)
+	_, err = rfs.Stat("absolute")
+	require.ErrorIs(t, err, unix.EXDEV)
+	content, err := rfs.ReadFile("dir/b")
+	require.NoError(t, err)
+	require.Equal(t, "inside b", string(content))
+}
@@ -0,0 +1,146 @@
+package syscallextra
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// si_code values for SIGCHLD (not in x/sys/unix)
+const (
+	cldExited = 1
+	cldKilled = 2
+	cldDumped = 3
+)
+
+// A handle to a process that can't be confused with another process after
+// pid reuse, all operations go through the pidfd instead of the pid
+type Pidfd struct {
+	fd  int
+	pid int
+}
+
+// Opens a pidfd for the given pid
+// NOTE: The pid could already have been reused before this is called, for
+// processes we spawn, prefer getting the pidfd from clone (SysProcAttr.PidFD)
+func PidfdOpen(pid int) (*Pidfd, error) {
+	var fd int
+	if err := WrapEINTR(func() (err error) {
+		fd, err = unix.PidfdOpen(pid, 0)
+		return
+	}); err != nil {
+		return nil, os.NewSyscallError("pidfd_open", err)
+	}
+	return &Pidfd{fd: fd, pid: pid}, nil
+}
+
+// Wraps an existing pidfd, like the one from SysProcAttr.PidFD, the Pidfd takes ownership of fd
+func NewPidfd(fd int, pid int) *Pidfd {
+	return &Pidfd{fd: fd, pid: pid}
+}
+
+// The raw pidfd
+func (p *Pidfd) Fd() int {
+	if p.fd < 0 {
+		panic("pidfd closed")
+	}
+	return p.fd
+}
+
+// The pid that the pidfd was opened for, for informational purposes only
+func (p *Pidfd) Pid() int {
+	return p.pid
+}
+
+// Closes the pidfd, it is an error to use it afterwards
+func (p *Pidfd) Close() error {
+	if p.fd < 0 {
+		panic("pidfd closed")
+	}
+	err := unix.Close(p.fd)
+	p.fd = -1
+	return err
+}
+
+// Sends a signal to the process, using pidfd_send_signal
+func (p *Pidfd) Signal(sig syscall.Signal) error {
+	if p.fd < 0 {
+		panic("pidfd closed")
+	}
+	return os.NewSyscallError("pidfd_send_signal", WrapEINTR(func() error {
+		return unix.PidfdSendSignal(p.fd, sig, nil, 0)
+	}))
+}
+
+// Duplicates targetFD from the process into our process, using pidfd_getfd
+// The returned fd has O_CLOEXEC set, caller is responsible for closing it
+// Requires ptrace permission over the process
+func (p *Pidfd) GetFD(targetFD int) (int, error) {
+	if p.fd < 0 {
+		panic("pidfd closed")
+	}
+	var fd int
+	if err := WrapEINTR(func() (err error) {
+		fd, err = unix.PidfdGetfd(p.fd, targetFD, 0)
+		return
+	}); err != nil {
+		return -1, os.NewSyscallError("pidfd_getfd", err)
+	}
+	return fd, nil
+}
+
+// Blocks until the process exits, or ctx is done.  This doesn't reap the
+// process, so it works for processes that aren't our children, and for
+// children that os/exec will Wait on
+func (p *Pidfd) WaitExited(ctx context.Context) error {
+	if p.fd < 0 {
+		panic("pidfd closed")
+	}
+	_, err := PollContext(ctx, p.fd, unix.POLLIN)
+	return err
+}
+
+// Blocks until the process exits, or ctx is done, then reaps it using
+// waitid(P_PIDFD).  The process must be our child
+func (p *Pidfd) Wait(ctx context.Context) (syscall.WaitStatus, error) {
+	if err := p.WaitExited(ctx); err != nil {
+		return 0, err
+	}
+
+	info := unix.Siginfo{}
+	if err := WrapEINTR(func() error {
+		return unix.Waitid(unix.P_PIDFD, p.fd, &info, unix.WEXITED, nil)
+	}); err != nil {
+		return 0, os.NewSyscallError("waitid", err)
+	}
+
+	f := sigchldFieldsOf(&info)
+	switch info.Code {
+	case cldExited:
+		return syscall.WaitStatus(f.Status << 8), nil
+	case cldKilled:
+		return syscall.WaitStatus(f.Status), nil
+	case cldDumped:
+		return syscall.WaitStatus(f.Status | 0x80), nil
+	default:
+		return 0, fmt.Errorf("waitid: unexpected si_code: %d", info.Code)
+	}
+}
+
+// The part of siginfo that is filled in for SIGCHLD, x/sys/unix leaves it opaque
+type sigchldFields struct {
+	Pid    int32
+	Uid    uint32
+	Status int32
+}
+
+func sigchldFieldsOf(info *unix.Siginfo) sigchldFields {
+	// The union follows signo, errno and code, aligned to the pointer size
+	align := unsafe.Sizeof(uintptr(0))
+	offset := (unsafe.Offsetof(info.Code) + unsafe.Sizeof(info.Code) + align - 1) &^ (align - 1)
+	return *(*sigchldFields)(unsafe.Add(unsafe.Pointer(info), offset))
+}
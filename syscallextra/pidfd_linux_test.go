@@ -0,0 +1,85 @@
+package syscallextra_test
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/syscallextra"
+	"golang.org/x/sys/unix"
+)
+
+func startPidfd(t *testing.T, name string, args ...string) (*exec.Cmd, *syscallextra.Pidfd) {
+	t.Helper()
+	cmd := exec.Command(name, args...)
+	require.NoError(t, cmd.Start())
+	p, err := syscallextra.PidfdOpen(cmd.Process.Pid)
+	require.NoError(t, err)
+	t.Cleanup(func() { require.NoError(t, p.Close()) })
+	return cmd, p
+}
+
+func TestPidfdSignalWait(t *testing.T) {
+	_, p := startPidfd(t, "sleep", "1000")
+
+	require.NoError(t, p.Signal(syscall.SIGTERM))
+	ws, err := p.Wait(context.Background())
+	require.NoError(t, err)
+	require.True(t, ws.Signaled())
+	require.Equal(t, syscall.SIGTERM, ws.Signal())
+
+	// Already reaped, so signals should fail instead of hitting a reused pid
+	require.ErrorIs(t, p.Signal(syscall.SIGTERM), unix.ESRCH)
+}
+
+func TestPidfdExitCode(t *testing.T) {
+	_, p := startPidfd(t, "sh", "-c", "exit 7")
+
+	ws, err := p.Wait(context.Background())
+	require.NoError(t, err)
+	require.True(t, ws.Exited())
+	require.Equal(t, 7, ws.ExitStatus())
+}
+
+func TestPidfdWaitCancel(t *testing.T) {
+	syscallextra.RequireNoFDLeaks(t)
+	cmd, p := startPidfd(t, "sleep", "1000")
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, p.WaitExited(ctx), context.DeadlineExceeded)
+
+	// Not reaped by WaitExited, so os/exec can still Wait
+	require.NoError(t, cmd.Process.Kill())
+	require.NoError(t, p.WaitExited(context.Background()))
+}
+
+func TestPidfdGetFD(t *testing.T) {
+	f, err := os.Open(t.TempDir())
+	require.NoError(t, err)
+	defer f.Close()
+
+	cmd := exec.Command("sleep", "1000")
+	cmd.ExtraFiles = []*os.File{f} // fd 3 in the child
+	require.NoError(t, cmd.Start())
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+
+	p, err := syscallextra.PidfdOpen(cmd.Process.Pid)
+	require.NoError(t, err)
+	defer p.Close()
+
+	fd, err := p.GetFD(3)
+	require.NoError(t, err)
+	defer unix.Close(fd)
+
+	got, err := syscallextra.DescribeOpenFD(fd)
+	require.NoError(t, err)
+	require.Equal(t, f.Name(), got)
+}
@@ -0,0 +1,98 @@
+package syscallextra
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+// Soft and hard resource limits, RlimInfinity means unlimited
+type Rlimit struct {
+	Soft uint64 // The limit the kernel enforces
+	Hard uint64 // Ceiling for Soft, only raised with CAP_SYS_RESOURCE
+}
+
+const RlimInfinity = unix.RLIM_INFINITY
+
+// Like "1024/524288" or "unlimited/unlimited" (soft/hard)
+func (r Rlimit) String() string {
+	return formatRlimitValue(r.Soft) + "/" + formatRlimitValue(r.Hard)
+}
+
+func formatRlimitValue(v uint64) string {
+	if v == RlimInfinity {
+		return "unlimited"
+	}
+	return strconv.FormatUint(v, 10)
+}
+
+var rlimitNames = map[int]string{
+	unix.RLIMIT_AS:         "as",
+	unix.RLIMIT_CORE:       "core",
+	unix.RLIMIT_CPU:        "cpu",
+	unix.RLIMIT_DATA:       "data",
+	unix.RLIMIT_FSIZE:      "fsize",
+	unix.RLIMIT_LOCKS:      "locks",
+	unix.RLIMIT_MEMLOCK:    "memlock",
+	unix.RLIMIT_MSGQUEUE:   "msgqueue",
+	unix.RLIMIT_NICE:       "nice",
+	unix.RLIMIT_NOFILE:     "nofile",
+	unix.RLIMIT_NPROC:      "nproc",
+	unix.RLIMIT_RSS:        "rss",
+	unix.RLIMIT_RTPRIO:     "rtprio",
+	unix.RLIMIT_RTTIME:     "rttime",
+	unix.RLIMIT_SIGPENDING: "sigpending",
+	unix.RLIMIT_STACK:      "stack",
+}
+
+// Short name of an RLIMIT_* resource, like "nofile", as used by prlimit(1)
+func RlimitName(resource int) string {
+	if name, ok := rlimitNames[resource]; ok {
+		return name
+	}
+	return fmt.Sprintf("rlimit(%d)", resource)
+}
+
+// Gets a resource limit of pid (0 for our process) using prlimit(2)
+func GetRlimit(pid int, resource int) (Rlimit, error) {
+	lim := unix.Rlimit{}
+	if err := WrapEINTR(func() error {
+		return unix.Prlimit(pid, resource, nil, &lim)
+	}); err != nil {
+		return Rlimit{}, os.NewSyscallError("prlimit "+RlimitName(resource), err)
+	}
+	return Rlimit{Soft: lim.Cur, Hard: lim.Max}, nil
+}
+
+// Sets a resource limit of pid (0 for our process) using prlimit(2), and
+// returns the previous limit.  Raising Hard needs CAP_SYS_RESOURCE, and
+// other pids need the same uids as us or CAP_SYS_RESOURCE
+// NOTE: For our process prefer syscall.Setrlimit for RLIMIT_NOFILE, the Go
+// runtime tracks that one to restore it for children
+func SetRlimit(pid int, resource int, limit Rlimit) (Rlimit, error) {
+	lim := unix.Rlimit{Cur: limit.Soft, Max: limit.Hard}
+	old := unix.Rlimit{}
+	if err := WrapEINTR(func() error {
+		return unix.Prlimit(pid, resource, &lim, &old)
+	}); err != nil {
+		return Rlimit{}, os.NewSyscallError("prlimit "+RlimitName(resource), err)
+	}
+	return Rlimit{Soft: old.Cur, Hard: old.Max}, nil
+}
+
+// Raises the soft limit of pid (0 for our process) to its hard limit, which
+// doesn't need any privileges, returns the new limit
+func RaiseSoftRlimit(pid int, resource int) (Rlimit, error) {
+	lim, err := GetRlimit(pid, resource)
+	if err != nil {
+		return lim, err
+	}
+	if lim.Soft == lim.Hard {
+		return lim, nil
+	}
+	lim.Soft = lim.Hard
+	_, err = SetRlimit(pid, resource, lim)
+	return lim, err
+}
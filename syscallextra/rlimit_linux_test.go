@@ -0,0 +1,43 @@
+package syscallextra_test
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/syscallextra"
+	"golang.org/x/sys/unix"
+)
+
+func TestRlimit(t *testing.T) {
+	self, err := syscallextra.GetRlimit(0, unix.RLIMIT_NOFILE)
+	require.NoError(t, err)
+	byPid, err := syscallextra.GetRlimit(os.Getpid(), unix.RLIMIT_NOFILE)
+	require.NoError(t, err)
+	require.Equal(t, self, byPid)
+	require.LessOrEqual(t, self.Soft, self.Hard)
+
+	cmd := exec.Command("sleep", "1000")
+	require.NoError(t, cmd.Start())
+	defer cmd.Wait()
+	defer cmd.Process.Kill()
+	pid := cmd.Process.Pid
+
+	// Lowering limits of our own child needs no privileges
+	child, err := syscallextra.GetRlimit(pid, unix.RLIMIT_CORE)
+	require.NoError(t, err)
+	old, err := syscallextra.SetRlimit(pid, unix.RLIMIT_CORE, syscallextra.Rlimit{Soft: 0, Hard: 4096})
+	require.NoError(t, err)
+	require.Equal(t, child, old)
+
+	raised, err := syscallextra.RaiseSoftRlimit(pid, unix.RLIMIT_CORE)
+	require.NoError(t, err)
+	require.Equal(t, syscallextra.Rlimit{Soft: 4096, Hard: 4096}, raised)
+	got, err := syscallextra.GetRlimit(pid, unix.RLIMIT_CORE)
+	require.NoError(t, err)
+	require.Equal(t, raised, got)
+
+	require.Equal(t, "4096/unlimited", syscallextra.Rlimit{Soft: 4096, Hard: syscallextra.RlimInfinity}.String())
+	require.Equal(t, "nofile", syscallextra.RlimitName(unix.RLIMIT_NOFILE))
+}
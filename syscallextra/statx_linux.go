@@ -0,0 +1,174 @@
+package syscallextra
+
+import (
+	"io/fs"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// Options for StatxAt and StatxFD
+type StatxOptions struct {
+	// AT_EMPTY_PATH, AT_SYMLINK_NOFOLLOW, AT_NO_AUTOMOUNT,
+	// AT_STATX_FORCE_SYNC, AT_STATX_DONT_SYNC...
+	Flags int
+	// STATX_* fields to request, 0 means DefaultStatxMask
+	// The kernel may return more or fewer, check StatxResult.Mask
+	Mask int
+}
+
+// Requested when StatxOptions.Mask is 0
+const DefaultStatxMask = unix.STATX_BASIC_STATS | unix.STATX_BTIME | unix.STATX_MNT_ID
+
+// Decoded STATX_ATTR_* attributes
+type StatxAttributes struct {
+	Compressed bool
+	Immutable  bool
+	Append     bool
+	NoDump     bool
+	Encrypted  bool
+	Automount  bool
+	MountRoot  bool
+	Verity     bool
+	DAX        bool
+}
+
+// Go friendly version of unix.Statx_t
+// Fields not in Mask were not filled in by the kernel
+type StatxResult struct {
+	Mask    uint32 // STATX_* fields that are valid
+	Mode    fs.FileMode
+	RawMode uint16 // st_mode, as returned by the kernel
+	Nlink   uint32
+	UID     uint32
+	GID     uint32
+	Ino     uint64
+	Size    uint64
+	Blocks  uint64 // In 512 byte units
+	Blksize uint32
+
+	Atime time.Time
+	Btime time.Time // Creation time, zero if not supported by the filesystem
+	Ctime time.Time
+	Mtime time.Time
+
+	RdevMajor, RdevMinor uint32
+	DevMajor, DevMinor   uint32
+	MountID              uint64
+
+	DioMemAlign    uint32
+	DioOffsetAlign uint32
+
+	Attributes StatxAttributes
+	// Which attributes the filesystem supports
+	AttributesSupported StatxAttributes
+
+	Raw unix.Statx_t
+}
+
+// Whether all of the given STATX_* fields are valid
+func (r *StatxResult) Has(mask int) bool {
+	return r.Mask&uint32(mask) == uint32(mask)
+}
+
+// Calls statx with a dirfd, flags and mask.  dirfd can be unix.AT_FDCWD, or
+// an fd for a directory (including O_PATH fds from PathFD)
+func StatxAt(dirfd int, path string, opts StatxOptions) (StatxResult, error) {
+	mask := opts.Mask
+	if mask == 0 {
+		mask = DefaultStatxMask
+	}
+	st := unix.Statx_t{}
+	if err := WrapEINTR(func() error {
+		return unix.Statx(dirfd, path, opts.Flags, mask, &st)
+	}); err != nil {
+		return StatxResult{}, &os.PathError{Op: "statx", Path: path, Err: err}
+	}
+	return newStatxResult(&st), nil
+}
+
+// Stats the file that fd refers to (using AT_EMPTY_PATH), fd can be an O_PATH fd
+func StatxFD(fd int, opts StatxOptions) (StatxResult, error) {
+	opts.Flags |= unix.AT_EMPTY_PATH
+	return StatxAt(fd, "", opts)
+}
+
+func statxTime(ts unix.StatxTimestamp) time.Time {
+	return time.Unix(ts.Sec, int64(ts.Nsec))
+}
+
+func decodeStatxAttributes(a uint64) StatxAttributes {
+	return StatxAttributes{
+		Compressed: a&unix.STATX_ATTR_COMPRESSED != 0,
+		Immutable:  a&unix.STATX_ATTR_IMMUTABLE != 0,
+		Append:     a&unix.STATX_ATTR_APPEND != 0,
+		NoDump:     a&unix.STATX_ATTR_NODUMP != 0,
+		Encrypted:  a&unix.STATX_ATTR_ENCRYPTED != 0,
+		Automount:  a&unix.STATX_ATTR_AUTOMOUNT != 0,
+		MountRoot:  a&unix.STATX_ATTR_MOUNT_ROOT != 0,
+		Verity:     a&unix.STATX_ATTR_VERITY != 0,
+		DAX:        a&unix.STATX_ATTR_DAX != 0,
+	}
+}
+
+// Converts st_mode to fs.FileMode, the same way os.Stat does
+func statxFileMode(mode uint16) fs.FileMode {
+	m := fs.FileMode(mode & 0777)
+	switch mode & unix.S_IFMT {
+	case unix.S_IFBLK:
+		m |= fs.ModeDevice
+	case unix.S_IFCHR:
+		m |= fs.ModeDevice | fs.ModeCharDevice
+	case unix.S_IFDIR:
+		m |= fs.ModeDir
+	case unix.S_IFIFO:
+		m |= fs.ModeNamedPipe
+	case unix.S_IFLNK:
+		m |= fs.ModeSymlink
+	case unix.S_IFSOCK:
+		m |= fs.ModeSocket
+	}
+	if mode&unix.S_ISGID != 0 {
+		m |= fs.ModeSetgid
+	}
+	if mode&unix.S_ISUID != 0 {
+		m |= fs.ModeSetuid
+	}
+	if mode&unix.S_ISVTX != 0 {
+		m |= fs.ModeSticky
+	}
+	return m
+}
+
+func newStatxResult(st *unix.Statx_t) StatxResult {
+	r := StatxResult{
+		Mask:                st.Mask,
+		Mode:                statxFileMode(st.Mode),
+		RawMode:             st.Mode,
+		Nlink:               st.Nlink,
+		UID:                 st.Uid,
+		GID:                 st.Gid,
+		Ino:                 st.Ino,
+		Size:                st.Size,
+		Blocks:              st.Blocks,
+		Blksize:             st.Blksize,
+		Atime:               statxTime(st.Atime),
+		Ctime:               statxTime(st.Ctime),
+		Mtime:               statxTime(st.Mtime),
+		RdevMajor:           st.Rdev_major,
+		RdevMinor:           st.Rdev_minor,
+		DevMajor:            st.Dev_major,
+		DevMinor:            st.Dev_minor,
+		MountID:             st.Mnt_id,
+		DioMemAlign:         st.Dio_mem_align,
+		DioOffsetAlign:      st.Dio_offset_align,
+		Attributes:          decodeStatxAttributes(st.Attributes & st.Attributes_mask),
+		AttributesSupported: decodeStatxAttributes(st.Attributes_mask),
+		Raw:                 *st,
+	}
+	if st.Mask&unix.STATX_BTIME != 0 {
+		r.Btime = statxTime(st.Btime)
+	}
+	return r
+}
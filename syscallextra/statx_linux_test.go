@@ -0,0 +1,71 @@
+package syscallextra_test
+
+import (
+	"context"
+	"os"
+	"slices"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/syscallextra"
+	"golang.org/x/sys/unix"
+)
+
+func TestStatxAt(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/file"
+	require.NoError(t, os.WriteFile(path, []byte("hello"), 0640))
+	require.NoError(t, os.Symlink("file", dir+"/link"))
+
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+
+	st, err := syscallextra.StatxAt(unix.AT_FDCWD, path, syscallextra.StatxOptions{})
+	require.NoError(t, err)
+	require.True(t, st.Has(unix.STATX_BASIC_STATS))
+	require.Equal(t, uint64(5), st.Size)
+	require.Equal(t, fi.Mode(), st.Mode)
+	require.Equal(t, fi.ModTime(), st.Mtime)
+	require.Equal(t, fi.Sys().(*syscall.Stat_t).Ino, st.Ino)
+
+	// Relative to a dirfd, without following the symlink
+	for dirfd, err := range syscallextra.PathFD(context.Background(), dir) {
+		require.NoError(t, err)
+
+		lst, err := syscallextra.StatxAt(dirfd, "link", syscallextra.StatxOptions{
+			Flags: unix.AT_SYMLINK_NOFOLLOW,
+		})
+		require.NoError(t, err)
+		require.Equal(t, os.ModeSymlink, lst.Mode.Type())
+
+		fst, err := syscallextra.StatxAt(dirfd, "link", syscallextra.StatxOptions{})
+		require.NoError(t, err)
+		require.Equal(t, st.Ino, fst.Ino)
+	}
+
+	for fd, err := range syscallextra.PathFD(context.Background(), path) {
+		require.NoError(t, err)
+		fst, err := syscallextra.StatxFD(fd, syscallextra.StatxOptions{Mask: unix.STATX_INO})
+		require.NoError(t, err)
+		require.True(t, fst.Has(unix.STATX_INO))
+		require.Equal(t, st.Ino, fst.Ino)
+	}
+
+	_, err = syscallextra.StatxAt(unix.AT_FDCWD, dir+"/missing", syscallextra.StatxOptions{})
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestStatxMountID(t *testing.T) {
+	st, err := syscallextra.StatxAt(unix.AT_FDCWD, "/", syscallextra.StatxOptions{})
+	require.NoError(t, err)
+	require.True(t, st.Has(unix.STATX_MNT_ID))
+	require.True(t, st.AttributesSupported.MountRoot)
+	require.True(t, st.Attributes.MountRoot)
+
+	mounts, err := syscallextra.SelfMountInfo()
+	require.NoError(t, err)
+	require.True(t, slices.ContainsFunc(mounts, func(m syscallextra.MountInfo) bool {
+		return m.MountPoint == "/" && uint64(m.ID) == st.MountID
+	}))
+}
@@ -0,0 +1,93 @@
+package tlscreds
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"gitlab.com/croepha/common-utils/loggingctx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Decides whether a peer may call a method, like "/helloworld.Greeter/SayHello"
+// Returns nil to allow the call, the error is logged but not sent to the peer
+type Policy func(ctx context.Context, method string, peer *PeerIdentity) error
+
+// A Policy that allows peers matching any of the entries
+type Allowlist struct {
+	CommonNames []string
+	DNSNames    []string
+	SPIFFEIDs   []string // Exact IDs, like "spiffe://example.org/api"
+	// Any ID in these trust domains, like "example.org"
+	SPIFFETrustDomains []string
+}
+
+// Fails if any entry is empty, which usually means an unset config value
+func (a Allowlist) Policy() (Policy, error) {
+	for _, field := range []struct {
+		name    string
+		entries []string
+	}{
+		{"CommonNames", a.CommonNames},
+		{"DNSNames", a.DNSNames},
+		{"SPIFFEIDs", a.SPIFFEIDs},
+		{"SPIFFETrustDomains", a.SPIFFETrustDomains},
+	} {
+		if slices.Contains(field.entries, "") {
+			return nil, fmt.Errorf("Allowlist.%s has an empty entry", field.name)
+		}
+	}
+	return func(ctx context.Context, method string, peer *PeerIdentity) error {
+		if peer.CommonName != "" && slices.Contains(a.CommonNames, peer.CommonName) {
+			return nil
+		}
+		for _, name := range peer.DNSNames {
+			if slices.Contains(a.DNSNames, name) {
+				return nil
+			}
+		}
+		if peer.SPIFFEID != nil {
+			if slices.Contains(a.SPIFFEIDs, peer.SPIFFEID.String()) ||
+				slices.Contains(a.SPIFFETrustDomains, peer.SPIFFEID.Host) {
+				return nil
+			}
+		}
+		return fmt.Errorf("peer not in allowlist")
+	}, nil
+}
+
+// Checks the policy for an incoming RPC, returns a status error for the peer
+func authorize(ctx context.Context, method string, policy Policy) error {
+	id, err := PeerIdentityFromContext(ctx)
+	if err != nil {
+		loggingctx.Warn(ctx, "tlscreds unauthenticated", "method", method, "error", err)
+		return status.Error(codes.Unauthenticated, "no verified client certificate")
+	}
+	if err := policy(ctx, method, id); err != nil {
+		loggingctx.Warn(ctx, "tlscreds denied", "method", method, "peer", id.String(), "error", err)
+		return status.Errorf(codes.PermissionDenied, "%s is not allowed to call %s", id.CommonName, method)
+	}
+	return nil
+}
+
+// Rejects unary calls from peers that the policy doesn't allow
+func UnaryServerInterceptor(policy Policy) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := authorize(ctx, info.FullMethod, policy); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Rejects streams from peers that the policy doesn't allow
+func StreamServerInterceptor(policy Policy) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := authorize(ss.Context(), info.FullMethod, policy); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
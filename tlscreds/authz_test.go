@@ -0,0 +1,196 @@
+package tlscreds_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/grpctest"
+	"gitlab.com/croepha/common-utils/loggingctx"
+	"gitlab.com/croepha/common-utils/tlscreds"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/examples/features/proto/echo"
+	"google.golang.org/grpc/status"
+)
+
+type echoServer struct {
+	echo.UnimplementedEchoServer
+}
+
+func (echoServer) UnaryEcho(ctx context.Context, req *echo.EchoRequest) (*echo.EchoResponse, error) {
+	id, err := tlscreds.PeerIdentityFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &echo.EchoResponse{Message: req.Message + " from " + id.String()}, nil
+}
+
+func (echoServer) ServerStreamingEcho(req *echo.EchoRequest, stream grpc.ServerStreamingServer[echo.EchoResponse]) error {
+	return stream.Send(&echo.EchoResponse{Message: req.Message})
+}
+
+// Starts an echo server that authorizes with policy, and returns a client
+// connected with a certificate issued for client
+func startAuthzServer(t *testing.T, policy tlscreds.Policy, client tlscreds.LeafOptions, serverOpts ...grpc.ServerOption) echo.EchoClient {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+
+	ca, err := tlscreds.NewDevCA(t.Name())
+	require.NoError(t, err)
+	serverCreds, clientCreds, err := ca.Credentials(
+		tlscreds.LeafOptions{CommonName: "server", IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}},
+		client,
+	)
+	require.NoError(t, err)
+
+	// serverOpts go first, so their interceptors run before the policy
+	server := grpc.NewServer(append(serverOpts,
+		grpc.Creds(serverCreds),
+		grpc.ChainUnaryInterceptor(tlscreds.UnaryServerInterceptor(policy)),
+		grpc.ChainStreamInterceptor(tlscreds.StreamServerInterceptor(policy)),
+	)...)
+	echo.RegisterEchoServer(server, echoServer{})
+
+	conn, err := grpc.NewClient("127.0.0.1",
+		grpc.WithTransportCredentials(clientCreds),
+		grpctest.StartTestGRPCTestServer(ctx, t, server),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return echo.NewEchoClient(conn)
+}
+
+func TestAllowlist(t *testing.T) {
+	apiID := &url.URL{Scheme: "spiffe", Host: "example.org", Path: "/api"}
+	policy, err := tlscreds.Allowlist{SPIFFEIDs: []string{apiID.String()}}.Policy()
+	require.NoError(t, err)
+
+	client := startAuthzServer(t, policy, tlscreds.LeafOptions{CommonName: "api", URIs: []*url.URL{apiID}})
+	resp, err := client.UnaryEcho(context.Background(), &echo.EchoRequest{Message: "hi"})
+	require.NoError(t, err)
+	require.Equal(t, "hi from CN=api "+apiID.String(), resp.Message)
+
+	stream, err := client.ServerStreamingEcho(context.Background(), &echo.EchoRequest{Message: "hi"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.NoError(t, err)
+
+	// Same trust domain, different workload
+	logs := bytes.Buffer{}
+	installLogger := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(loggingctx.Context(ctx, slog.NewJSONHandler(&logs, nil)), req)
+	}
+	otherID := &url.URL{Scheme: "spiffe", Host: "example.org", Path: "/batch"}
+	client = startAuthzServer(t, policy, tlscreds.LeafOptions{CommonName: "batch", URIs: []*url.URL{otherID}},
+		grpc.ChainUnaryInterceptor(installLogger))
+
+	_, err = client.UnaryEcho(context.Background(), &echo.EchoRequest{Message: "hi"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+	logged := map[string]any{}
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &logged))
+	delete(logged, "time")
+	require.Equal(t, map[string]any{
+		"level":  "WARN",
+		"msg":    "tlscreds denied",
+		"method": echo.Echo_UnaryEcho_FullMethodName,
+		"peer":   "CN=batch " + otherID.String(),
+		"error":  "peer not in allowlist",
+	}, logged)
+
+	stream, err = client.ServerStreamingEcho(context.Background(), &echo.EchoRequest{Message: "hi"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// The whole trust domain
+	policy, err = tlscreds.Allowlist{SPIFFETrustDomains: []string{"example.org"}}.Policy()
+	require.NoError(t, err)
+	client = startAuthzServer(t, policy, tlscreds.LeafOptions{CommonName: "batch", URIs: []*url.URL{otherID}})
+	_, err = client.UnaryEcho(context.Background(), &echo.EchoRequest{Message: "hi"})
+	require.NoError(t, err)
+}
+
+func TestAllowlistEmptyEntries(t *testing.T) {
+	// Like a CN from an unset config value, it would allow peers without a CN
+	_, err := tlscreds.Allowlist{CommonNames: []string{"api", ""}}.Policy()
+	require.EqualError(t, err, "Allowlist.CommonNames has an empty entry")
+
+	policy, err := tlscreds.Allowlist{CommonNames: []string{"api"}}.Policy()
+	require.NoError(t, err)
+	client := startAuthzServer(t, policy, tlscreds.LeafOptions{})
+	_, err = client.UnaryEcho(context.Background(), &echo.EchoRequest{Message: "hi"})
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+// SPIFFE verification rejects a certificate with more than one URI SAN, so
+// the allowlist must not accept it either
+func TestAllowlistMultipleSPIFFEIDs(t *testing.T) {
+	apiID := &url.URL{Scheme: "spiffe", Host: "example.org", Path: "/api"}
+	otherID := &url.URL{Scheme: "spiffe", Host: "example.org", Path: "/batch"}
+	client := tlscreds.LeafOptions{CommonName: "api", URIs: []*url.URL{apiID, otherID}}
+
+	ca, err := tlscreds.NewDevCA(t.Name())
+	require.NoError(t, err)
+	leaf, err := ca.Issue(client)
+	require.NoError(t, err)
+	id := tlscreds.NewPeerIdentity(leaf.Cert.Leaf)
+	require.Nil(t, id.SPIFFEID)
+	require.Equal(t, []*url.URL{apiID, otherID}, id.URIs)
+
+	for _, allowlist := range []tlscreds.Allowlist{
+		{SPIFFEIDs: []string{apiID.String()}},
+		{SPIFFETrustDomains: []string{"example.org"}},
+	} {
+		policy, err := allowlist.Policy()
+		require.NoError(t, err)
+		_, err = startAuthzServer(t, policy, client).UnaryEcho(context.Background(), &echo.EchoRequest{Message: "hi"})
+		require.Equal(t, codes.PermissionDenied, status.Code(err))
+	}
+}
+
+func TestPolicyCallback(t *testing.T) {
+	policy := func(ctx context.Context, method string, peer *tlscreds.PeerIdentity) error {
+		if method == echo.Echo_ServerStreamingEcho_FullMethodName && peer.CommonName != "admin" {
+			return status.Error(codes.PermissionDenied, "admins only")
+		}
+		return nil
+	}
+	client := startAuthzServer(t, policy, tlscreds.LeafOptions{CommonName: "user"})
+	_, err := client.UnaryEcho(context.Background(), &echo.EchoRequest{Message: "hi"})
+	require.NoError(t, err)
+
+	stream, err := client.ServerStreamingEcho(context.Background(), &echo.EchoRequest{Message: "hi"})
+	require.NoError(t, err)
+	_, err = stream.Recv()
+	require.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestNoPeerCertificate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := tlscreds.PeerIdentityFromContext(ctx)
+	require.ErrorIs(t, err, tlscreds.ErrNoPeerCertificate)
+
+	allowAll := func(context.Context, string, *tlscreds.PeerIdentity) error { return nil }
+	server := grpc.NewServer(grpc.UnaryInterceptor(tlscreds.UnaryServerInterceptor(allowAll)))
+	echo.RegisterEchoServer(server, echoServer{})
+	conn, err := grpc.NewClient("127.0.0.1",
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpctest.StartTestGRPCTestServer(ctx, t, server),
+	)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = echo.NewEchoClient(conn).UnaryEcho(ctx, &echo.EchoRequest{Message: "hi"})
+	require.Equal(t, codes.Unauthenticated, status.Code(err))
+}
@@ -0,0 +1,134 @@
+package tlscreds
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Returned from the handshake when the peer's certificate was revoked
+var ErrRevoked = errors.New("certificate revoked")
+
+// Certificate revocation lists loaded from files, re-read when they change
+type crlSet struct {
+	paths []string
+
+	mut    sync.Mutex // Protects the following members
+	loaded []os.FileInfo
+	lists  []*x509.RevocationList
+}
+
+func loadCRLSet(paths []string) (*crlSet, error) {
+	s := &crlSet{paths: paths}
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if err := s.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Must be called with mut held
+func (s *crlSet) reloadIfChanged() error {
+	stats := make([]os.FileInfo, len(s.paths))
+	changed := len(s.loaded) != len(s.paths)
+	for i, path := range s.paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		stats[i] = fi
+		if !changed && !sameFileVersion(fi, s.loaded[i]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	lists := []*x509.RevocationList{}
+	for _, path := range s.paths {
+		l, err := readCRL(path)
+		if err != nil {
+			return err
+		}
+		lists = append(lists, l...)
+	}
+	s.lists = lists
+	s.loaded = stats
+	return nil
+}
+
+// Reads a file of PEM "X509 CRL" blocks, or a single DER CRL
+func readCRL(path string) ([]*x509.RevocationList, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ders := [][]byte{}
+	rest := b
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "X509 CRL" {
+			ders = append(ders, block.Bytes)
+		}
+	}
+	if len(ders) == 0 {
+		ders = append(ders, b)
+	}
+	lists := []*x509.RevocationList{}
+	for _, der := range ders {
+		l, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		lists = append(lists, l)
+	}
+	return lists, nil
+}
+
+// Rejects chains where a certificate is listed in a CRL signed by its issuer
+// If the files can't be re-read, the previously loaded lists keep being used
+func (s *crlSet) check(verifiedChains [][]*x509.Certificate) error {
+	s.mut.Lock()
+	if err := s.reloadIfChanged(); err != nil {
+		slog.Warn("tlscreds CRL reload", "error", err)
+	}
+	lists := s.lists
+	s.mut.Unlock()
+
+	for _, chain := range verifiedChains {
+		for i := 0; i+1 < len(chain); i++ {
+			if err := checkRevoked(lists, chain[i], chain[i+1]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func checkRevoked(lists []*x509.RevocationList, cert, issuer *x509.Certificate) error {
+	for _, l := range lists {
+		if l.CheckSignatureFrom(issuer) != nil {
+			continue
+		}
+		if !l.NextUpdate.IsZero() && time.Now().After(l.NextUpdate) {
+			slog.Warn("tlscreds CRL is past its next update", "issuer", issuer.Subject.String(), "nextUpdate", l.NextUpdate)
+		}
+		for _, entry := range l.RevokedCertificateEntries {
+			if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return fmt.Errorf("%w: %s serial %s", ErrRevoked, cert.Subject.String(), cert.SerialNumber)
+			}
+		}
+	}
+	return nil
+}
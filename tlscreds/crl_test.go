@@ -0,0 +1,70 @@
+package tlscreds
+
+import (
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCRL(t *testing.T) {
+	dir := t.TempDir()
+	ca := newDevCA(t)
+	issueFiles(t, ca, filepath.Join(dir, "server"), "server")
+	issueFiles(t, ca, filepath.Join(dir, "good"), "good")
+	issueFiles(t, ca, filepath.Join(dir, "revoked"), "revoked")
+
+	crlPath := filepath.Join(dir, "ca.crl")
+	writeCRL := func(revoked ...string) {
+		t.Helper()
+		crl, err := ca.RevocationList()
+		for _, name := range revoked {
+			cert, err := loadKeyPair(filepath.Join(dir, name+"-cert.pem"), filepath.Join(dir, name+"-key.pem"))
+			require.NoError(t, err)
+			crl, err = ca.RevocationList(cert.Leaf.SerialNumber)
+			require.NoError(t, err)
+		}
+		require.NoError(t, err)
+		require.NoError(t, writeFileAtomic(crlPath, crl, 0644))
+	}
+	writeCRL("revoked")
+
+	opts := Options{Trust: ca.Trust()}
+	server, err := LoadServerCredentials(filepath.Join(dir, "server"), Options{Trust: ca.Trust(), CRLFiles: []string{crlPath}})
+	require.NoError(t, err)
+	defer server.Close()
+	good, err := LoadClientCredentials(filepath.Join(dir, "good"), opts)
+	require.NoError(t, err)
+	defer good.Close()
+	revoked, err := LoadClientCredentials(filepath.Join(dir, "revoked"), opts)
+	require.NoError(t, err)
+	defer revoked.Close()
+
+	_, _, err = handshake(t, server, good)
+	require.NoError(t, err)
+	_, _, err = handshake(t, server, revoked)
+	require.ErrorIs(t, err, ErrRevoked)
+
+	// Changes to the file are picked up
+	writeCRL("good")
+	_, _, err = handshake(t, server, good)
+	require.ErrorIs(t, err, ErrRevoked)
+	_, _, err = handshake(t, server, revoked)
+	require.NoError(t, err)
+
+	// A CRL from another CA doesn't apply
+	other := newDevCA(t)
+	otherCRL, err := other.RevocationList(big.NewInt(2), big.NewInt(3), big.NewInt(4))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other.crl"), otherCRL, 0644))
+	server2, err := LoadServerCredentials(filepath.Join(dir, "server"), Options{Trust: ca.Trust(), CRLFiles: []string{filepath.Join(dir, "other.crl")}})
+	require.NoError(t, err)
+	defer server2.Close()
+	_, _, err = handshake(t, server2, good)
+	require.NoError(t, err)
+
+	_, err = LoadServerCredentials(filepath.Join(dir, "server"), Options{Trust: ca.Trust(), CRLFiles: []string{filepath.Join(dir, "missing.crl")}})
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
@@ -0,0 +1,246 @@
+package tlscreds
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+/*
+A throwaway certificate authority for tests and local development, it is
+created in memory and never persisted unless asked to
+
+	ca, err := tlscreds.NewDevCA("my dev CA")
+	leaf, err := ca.Issue(tlscreds.LeafOptions{DNSNames: []string{"localhost"}})
+	err = leaf.WriteFiles("/tmp/dev/server") // For LoadServerCredentials("/tmp/dev/server", ...)
+*/
+type DevCA struct {
+	Cert    *x509.Certificate
+	CertPEM []byte
+	key     crypto.Signer
+
+	mut        sync.Mutex // Protects the following members
+	lastSerial int64
+}
+
+// How long NewDevCA's root is valid for
+const devCALifetime = 10 * 365 * 24 * time.Hour
+
+// Generates a new root certificate and key
+func NewDevCA(name string) (*DevCA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(devCALifetime),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("x509.CreateCertificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	return &DevCA{
+		Cert:       cert,
+		CertPEM:    pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		key:        key,
+		lastSerial: 1,
+	}, nil
+}
+
+var sharedDevCA = sync.OnceValues(func() (*DevCA, error) {
+	return NewDevCA("tlscreds shared dev CA")
+})
+
+// A DevCA shared by the whole process, created on first use, so tests in
+// one binary can trust each other's certificates
+func SharedDevCA() (*DevCA, error) {
+	return sharedDevCA()
+}
+
+// Trust options that trust only this CA
+func (ca *DevCA) Trust() TrustOptions {
+	return TrustOptions{PEM: [][]byte{ca.CertPEM}}
+}
+
+// Writes the CA certificate, for use with TrustOptions.Files
+func (ca *DevCA) WriteCertFile(path string) error {
+	return os.WriteFile(path, ca.CertPEM, 0644)
+}
+
+// What to put in an issued certificate
+type LeafOptions struct {
+	CommonName  string
+	DNSNames    []string
+	IPAddresses []net.IP
+	URIs        []*url.URL // SPIFFE IDs, like spiffe://example.org/ns/prod/sa/api
+
+	// Which ExtKeyUsages to include, when neither is set the certificate
+	// can be used for both
+	Server bool
+	Client bool
+
+	// Defaults to a day
+	Lifetime time.Duration
+}
+
+// A certificate issued by a DevCA
+type DevLeaf struct {
+	CertPEM []byte
+	KeyPEM  []byte
+	Cert    *tls.Certificate // With Leaf filled in
+}
+
+// Issues a leaf certificate with a new key
+func (ca *DevCA) Issue(opts LeafOptions) (*DevLeaf, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	lifetime := opts.Lifetime
+	if lifetime == 0 {
+		lifetime = 24 * time.Hour
+	}
+	usages := []x509.ExtKeyUsage{}
+	if opts.Server || !opts.Client {
+		usages = append(usages, x509.ExtKeyUsageServerAuth)
+	}
+	if opts.Client || !opts.Server {
+		usages = append(usages, x509.ExtKeyUsageClientAuth)
+	}
+
+	ca.mut.Lock()
+	ca.lastSerial++
+	serial := ca.lastSerial
+	ca.mut.Unlock()
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: opts.CommonName},
+		NotBefore:    now.Add(-time.Minute),
+		NotAfter:     now.Add(lifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  usages,
+		DNSNames:     opts.DNSNames,
+		IPAddresses:  opts.IPAddresses,
+		URIs:         opts.URIs,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.Cert, key.Public(), ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("x509.CreateCertificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	leaf := &DevLeaf{
+		CertPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		KeyPEM:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}
+	cert, err := tls.X509KeyPair(leaf.CertPEM, leaf.KeyPEM)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf, err = x509.ParseCertificate(der); err != nil {
+		return nil, err
+	}
+	leaf.Cert = &cert
+	return leaf, nil
+}
+
+// Writes identityPath-cert.pem and identityPath-key.pem, the layout that
+// LoadServerCredentials and LoadClientCredentials read
+// Each file is replaced atomically, but not the pair, so a reloading reader
+// can briefly see a new key with the old certificate.  loadKeyPair rejects
+// the mismatched pair and the reloader keeps the old one until its next check
+func (l *DevLeaf) WriteFiles(identityPath string) error {
+	if err := writeFileAtomic(identityPath+"-key.pem", l.KeyPEM, 0600); err != nil {
+		return err
+	}
+	return writeFileAtomic(identityPath+"-cert.pem", l.CertPEM, 0644)
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Issues a server and a client certificate, and returns in memory
+// credentials for both that trust only this CA, for grpc.Creds and
+// grpc.WithTransportCredentials
+func (ca *DevCA) Credentials(server, client LeafOptions) (serverCreds, clientCreds *Credentials, err error) {
+	server.Server = true
+	client.Client = true
+	serverLeaf, err := ca.Issue(server)
+	if err != nil {
+		return nil, nil, err
+	}
+	clientLeaf, err := ca.Issue(client)
+	if err != nil {
+		return nil, nil, err
+	}
+	opts := Options{Trust: ca.Trust()}
+	if serverCreds, err = NewServerCredentials(serverLeaf.Cert, opts); err != nil {
+		return nil, nil, err
+	}
+	if clientCreds, err = NewClientCredentials(clientLeaf.Cert, opts); err != nil {
+		serverCreds.Close()
+		return nil, nil, err
+	}
+	return serverCreds, clientCreds, nil
+}
+
+// Issues a PEM CRL that revokes the given serial numbers (see
+// DevLeaf.Cert.Leaf.SerialNumber), for Options.CRLFiles
+func (ca *DevCA) RevocationList(serials ...*big.Int) ([]byte, error) {
+	ca.mut.Lock()
+	ca.lastSerial++
+	number := ca.lastSerial
+	ca.mut.Unlock()
+
+	now := time.Now()
+	entries := []x509.RevocationListEntry{}
+	for _, serial := range serials {
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: now})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(number),
+		ThisUpdate:                now.Add(-time.Minute),
+		NextUpdate:                now.Add(24 * time.Hour),
+		RevokedCertificateEntries: entries,
+	}, ca.Cert, ca.key)
+	if err != nil {
+		return nil, fmt.Errorf("x509.CreateRevocationList: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), nil
+}
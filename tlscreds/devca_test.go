@@ -0,0 +1,73 @@
+package tlscreds
+
+import (
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/credentials"
+)
+
+func TestDevCACredentials(t *testing.T) {
+	ca := newDevCA(t)
+	spiffeID := &url.URL{Scheme: "spiffe", Host: "example.org", Path: "/client"}
+	server, client, err := ca.Credentials(
+		LeafOptions{CommonName: "server", IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}},
+		LeafOptions{CommonName: "client", URIs: []*url.URL{spiffeID}},
+	)
+	require.NoError(t, err)
+	defer server.Close()
+	defer client.Close()
+
+	serverSaw, _, err := handshake(t, server, client)
+	require.NoError(t, err)
+	peer := serverSaw.(credentials.TLSInfo).State.PeerCertificates[0]
+	require.Equal(t, "client", peer.Subject.CommonName)
+	require.Equal(t, []*url.URL{spiffeID}, peer.URIs)
+
+	// Reloading needs files
+	_, err = NewServerCredentials(server.Certificate(), Options{Trust: ca.Trust(), ReloadInterval: time.Second})
+	require.Error(t, err)
+
+	// Server only certificates can't be used as clients
+	serverOnly, err := ca.Issue(LeafOptions{CommonName: "server", Server: true})
+	require.NoError(t, err)
+	misused, err := NewClientCredentials(serverOnly.Cert, Options{Trust: ca.Trust()})
+	require.NoError(t, err)
+	_, _, err = handshake(t, server, misused)
+	require.Error(t, err)
+}
+
+func TestDevCAFiles(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := SharedDevCA()
+	require.NoError(t, err)
+	again, err := SharedDevCA()
+	require.NoError(t, err)
+	require.Same(t, ca, again)
+
+	require.NoError(t, ca.WriteCertFile(filepath.Join(dir, "ca.pem")))
+	for _, name := range []string{"server", "client"} {
+		leaf, err := ca.Issue(LeafOptions{CommonName: name, DNSNames: []string{"localhost"}, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}})
+		require.NoError(t, err)
+		require.NoError(t, leaf.WriteFiles(filepath.Join(dir, name)))
+	}
+	fi, err := os.Stat(filepath.Join(dir, "server-key.pem"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+
+	opts := Options{Trust: TrustOptions{Files: []string{filepath.Join(dir, "ca.pem")}}}
+	server, err := LoadServerCredentials(filepath.Join(dir, "server"), opts)
+	require.NoError(t, err)
+	defer server.Close()
+	client, err := LoadClientCredentials(filepath.Join(dir, "client"), opts)
+	require.NoError(t, err)
+	defer client.Close()
+	_, clientSaw, err := handshake(t, server, client)
+	require.NoError(t, err)
+	require.Equal(t, []string{"localhost"}, clientSaw.(credentials.TLSInfo).State.PeerCertificates[0].DNSNames)
+}
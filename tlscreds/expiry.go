@@ -0,0 +1,118 @@
+package tlscreds
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.com/croepha/common-utils/ministats"
+)
+
+// Options for watching the certificate's expiry
+type ExpiryOptions struct {
+	// Warn through slog on every check once the certificate expires within this
+	WarnBefore time.Duration
+	// How often to check, defaults to an hour
+	CheckInterval time.Duration
+	// When set, the seconds left before expiry are published as StatsName
+	// (which must be unique in the Service until Close), 0 once expired
+	Stats     *ministats.Service
+	StatsName string
+}
+
+func (o ExpiryOptions) enabled() bool {
+	return o.WarnBefore > 0 || o.Stats != nil
+}
+
+// The time the certificate currently presented to peers expires
+func (c *Credentials) NotAfter() time.Time {
+	return c.Certificate().Leaf.NotAfter
+}
+
+// How long until the certificate expires, negative once it has
+func (c *Credentials) ExpiresIn() time.Duration {
+	return time.Until(c.NotAfter())
+}
+
+type expiryMonitor struct {
+	opts    ExpiryOptions
+	id      *identity
+	seconds atomic.Uint64
+	stats   interface {
+		AfterChange()
+		Remove()
+	}
+
+	stopCh   chan struct{}
+	stopOnce sync.Once
+	watching sync.WaitGroup
+}
+
+func startExpiryMonitor(id *identity, opts ExpiryOptions) (*expiryMonitor, error) {
+	if opts.CheckInterval == 0 {
+		opts.CheckInterval = time.Hour
+	}
+	if opts.Stats != nil && opts.StatsName == "" {
+		return nil, fmt.Errorf("ExpiryOptions.StatsName is required with Stats")
+	}
+	m := &expiryMonitor{opts: opts, id: id, stopCh: make(chan struct{})}
+	if opts.Stats != nil {
+		stats, err := opts.Stats.TryAdd(opts.StatsName, &m.seconds)
+		if err != nil {
+			return nil, fmt.Errorf("ExpiryOptions.StatsName: %w", err)
+		}
+		m.stats = stats
+	}
+	m.check()
+
+	m.watching.Add(1)
+	go func() {
+		defer m.watching.Done()
+		ticker := time.NewTicker(opts.CheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.stopCh:
+				return
+			case <-ticker.C:
+			}
+			m.check()
+		}
+	}()
+	return m, nil
+}
+
+func (m *expiryMonitor) check() {
+	leaf := m.id.current().Leaf
+	remaining := time.Until(leaf.NotAfter)
+
+	m.seconds.Store(uint64(max(remaining, 0) / time.Second))
+	if m.stats != nil {
+		m.stats.AfterChange()
+	}
+
+	if remaining <= 0 {
+		slog.Error("tlscreds certificate expired",
+			"subject", leaf.Subject.String(),
+			"notAfter", leaf.NotAfter,
+		)
+	} else if remaining < m.opts.WarnBefore {
+		slog.Warn("tlscreds certificate expires soon",
+			"subject", leaf.Subject.String(),
+			"notAfter", leaf.NotAfter,
+			"remaining", remaining.Round(time.Second).String(),
+		)
+	}
+}
+
+func (m *expiryMonitor) stop() {
+	m.stopOnce.Do(func() {
+		close(m.stopCh)
+		m.watching.Wait()
+		if m.stats != nil {
+			m.stats.Remove()
+		}
+	})
+}
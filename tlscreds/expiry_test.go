@@ -0,0 +1,88 @@
+package tlscreds
+
+import (
+	"bytes"
+	"log/slog"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/croepha/common-utils/ministats"
+)
+
+// Captures slog.Default output for the rest of the test
+func captureSlog(t *testing.T) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return buf
+}
+
+func TestExpiryMonitor(t *testing.T) {
+	ca := newDevCA(t)
+	leaf, err := ca.Issue(LeafOptions{CommonName: "short", Lifetime: 30 * time.Minute})
+	require.NoError(t, err)
+
+	logs := captureSlog(t)
+	stats := &ministats.Service{}
+	creds, err := NewServerCredentials(leaf.Cert, Options{
+		Trust: ca.Trust(),
+		Expiry: ExpiryOptions{
+			WarnBefore:    time.Hour,
+			CheckInterval: 10 * time.Millisecond,
+			Stats:         stats,
+			StatsName:     "tls_expiry_seconds",
+		},
+	})
+	require.NoError(t, err)
+	defer creds.Close()
+
+	require.Equal(t, leaf.Cert.Leaf.NotAfter, creds.NotAfter())
+	require.InDelta(t, 30*time.Minute, creds.ExpiresIn(), float64(time.Minute))
+	require.InDelta(t, 30*60, creds.expiry.seconds.Load(), 60)
+	require.Contains(t, logs.String(), "tlscreds certificate expires soon")
+	require.Contains(t, logs.String(), `subject="CN=short"`)
+
+	_, err = NewServerCredentials(leaf.Cert, Options{Trust: ca.Trust(), Expiry: ExpiryOptions{Stats: stats}})
+	require.Error(t, err)
+}
+
+// Restarting with the same StatsName works once the old credentials are
+// closed, and a name that is still in use is an error rather than a panic
+func TestExpiryMonitorStatsName(t *testing.T) {
+	ca := newDevCA(t)
+	leaf, err := ca.Issue(LeafOptions{CommonName: "server"})
+	require.NoError(t, err)
+
+	stats := &ministats.Service{}
+	opts := Options{Trust: ca.Trust(), Expiry: ExpiryOptions{Stats: stats, StatsName: "tls_expiry_seconds"}}
+	for range 3 {
+		creds, err := NewServerCredentials(leaf.Cert, opts)
+		require.NoError(t, err)
+		require.NoError(t, creds.Close())
+	}
+
+	other := stats.Add("other", &atomic.Uint64{})
+	defer other.Remove()
+	opts.Expiry.StatsName = "other"
+	_, err = NewServerCredentials(leaf.Cert, opts)
+	require.ErrorIs(t, err, ministats.ErrNameUsed)
+}
+
+func TestExpiryMonitorQuiet(t *testing.T) {
+	dir := t.TempDir()
+	ca := newDevCA(t)
+	issueFiles(t, ca, filepath.Join(dir, "server"), "server")
+
+	logs := captureSlog(t)
+	creds, err := LoadServerCredentials(filepath.Join(dir, "server"), Options{
+		Trust:  ca.Trust(),
+		Expiry: ExpiryOptions{WarnBefore: time.Hour},
+	})
+	require.NoError(t, err)
+	require.NoError(t, creds.Close())
+	require.NotContains(t, logs.String(), "tlscreds certificate")
+}
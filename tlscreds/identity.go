@@ -0,0 +1,138 @@
+package tlscreds
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A cert/key pair loaded from files, that can be reloaded when they change
+type identity struct {
+	certFilePath string
+	keyFilePath  string
+	cert         atomic.Pointer[tls.Certificate]
+
+	mut      sync.Mutex // Protects the following members
+	loaded   [2]os.FileInfo
+	stopCh   chan struct{}
+	watching sync.WaitGroup
+}
+
+func loadIdentity(identityPath string) (*identity, error) {
+	id := &identity{
+		certFilePath: identityPath + "-cert.pem",
+		keyFilePath:  identityPath + "-key.pem",
+	}
+	if _, err := id.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+	return id, nil
+}
+
+// An identity that never changes, for certificates that aren't from files
+func staticIdentity(cert *tls.Certificate) *identity {
+	id := &identity{}
+	id.cert.Store(cert)
+	return id
+}
+
+func (id *identity) current() *tls.Certificate {
+	return id.cert.Load()
+}
+
+func (id *identity) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return id.current(), nil
+}
+
+func (id *identity) getClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return id.current(), nil
+}
+
+// Loads the files if they are different from the ones loaded last time,
+// returns whether a new certificate was loaded
+// If loading fails (like when only one of the files was replaced so far)
+// the old certificate stays, and the next call tries again
+func (id *identity) reloadIfChanged() (bool, error) {
+	if id.certFilePath == "" {
+		return false, nil
+	}
+	id.mut.Lock()
+	defer id.mut.Unlock()
+
+	stats := [2]os.FileInfo{}
+	for i, path := range []string{id.certFilePath, id.keyFilePath} {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return false, err
+		}
+		stats[i] = fi
+	}
+	if id.cert.Load() != nil && sameFileVersion(stats[0], id.loaded[0]) && sameFileVersion(stats[1], id.loaded[1]) {
+		return false, nil
+	}
+
+	cert, err := loadKeyPair(id.certFilePath, id.keyFilePath)
+	if err != nil {
+		return false, err
+	}
+	id.cert.Store(cert)
+	id.loaded = stats
+	return true, nil
+}
+
+// Also notices files replaced by rename, or by swapping a symlink, the way
+// kubernetes updates mounted secrets
+func sameFileVersion(a, b os.FileInfo) bool {
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
+
+// Starts checking the files in the background
+func (id *identity) watch(interval time.Duration) {
+	id.mut.Lock()
+	defer id.mut.Unlock()
+	if id.stopCh != nil {
+		panic("already watching")
+	}
+	stopCh := make(chan struct{})
+	id.stopCh = stopCh
+
+	id.watching.Add(1)
+	go func() {
+		defer id.watching.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+			}
+			changed, err := id.reloadIfChanged()
+			if err != nil {
+				slog.Warn("tlscreds reload", "certFilePath", id.certFilePath, "error", err)
+			}
+			if changed {
+				slog.Info("tlscreds reloaded",
+					"certFilePath", id.certFilePath,
+					"notAfter", id.current().Leaf.NotAfter,
+				)
+			}
+		}
+	}()
+}
+
+// Stops the background checking, NOP if it isn't running
+func (id *identity) stop() {
+	id.mut.Lock()
+	stopCh := id.stopCh
+	id.stopCh = nil
+	id.mut.Unlock()
+
+	if stopCh != nil {
+		close(stopCh)
+		id.watching.Wait()
+	}
+}
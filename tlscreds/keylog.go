@@ -0,0 +1,124 @@
+package tlscreds
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// Returned when key logging is requested in a binary built with -tags release
+var ErrKeyLogDisabled = errors.New("tls key logging is disabled in release builds")
+
+// Writes the TLS session secrets (in the NSS key log format that wireshark
+// reads) so connections can be decrypted while debugging
+// INSECURE: anyone with the file can read the traffic
+type KeyLogOptions struct {
+	// Appended to, created with mode 0600, refused if it is a symlink, isn't
+	// owned by us, or other users can access it
+	Path string
+	// Only log connections with these peers, matched against the remote
+	// "host:port" or host, and for clients the dialed authority too
+	// Empty means every connection
+	Peers []string
+}
+
+// Opens the key log file, refusing symlinks, files that aren't regular,
+// files owned by another user and files other users can access
+func openKeyLog(path string) (*os.File, error) {
+	if !keyLogAllowed {
+		return nil, ErrKeyLogDisabled
+	}
+	// A planted symlink would send the secrets somewhere else
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND|keyLogOpenFlags, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("keylog open file: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		f.Close()
+		return nil, fmt.Errorf("keylog file %s is not a regular file (mode %s)", path, fi.Mode())
+	}
+	if err := keyLogCheckOwner(fi); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("keylog file %s: %w", path, err)
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		f.Close()
+		return nil, fmt.Errorf("keylog file %s is accessible by other users (mode %s)", path, fi.Mode().Perm())
+	}
+	return f, nil
+}
+
+// Sets up c to log keys for matching peers, with a copy of tlsConfig
+func (c *Credentials) enableKeyLog(tlsConfig *tls.Config, opts KeyLogOptions) error {
+	f, err := openKeyLog(opts.Path)
+	if err != nil {
+		return err
+	}
+	slog.Warn("tlscreds key logging is enabled. Security is compromised", "path", opts.Path, "peers", opts.Peers)
+	logged := tlsConfig.Clone()
+	logged.KeyLogWriter = f
+	c.keyLogFile = f
+	c.keyLogged = credentials.NewTLS(logged)
+	c.keyLogPeers = opts.Peers
+	return nil
+}
+
+func (c *Credentials) keyLogMatches(authority string, remote net.Addr) bool {
+	if c.keyLogged == nil {
+		return false
+	}
+	if len(c.keyLogPeers) == 0 {
+		return true
+	}
+	if authority != "" && slices.Contains(c.keyLogPeers, authority) {
+		return true
+	}
+	if remote == nil {
+		return false
+	}
+	addr := remote.String()
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	return slices.Contains(c.keyLogPeers, addr) || slices.Contains(c.keyLogPeers, host)
+}
+
+// Implements credentials.TransportCredentials, logging keys if the peer matches
+func (c *Credentials) ClientHandshake(ctx context.Context, authority string, rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	if c.keyLogMatches(authority, rawConn.RemoteAddr()) {
+		return c.keyLogged.ClientHandshake(ctx, authority, rawConn)
+	}
+	return c.TransportCredentials.ClientHandshake(ctx, authority, rawConn)
+}
+
+// Implements credentials.TransportCredentials, logging keys if the peer matches
+func (c *Credentials) ServerHandshake(rawConn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	if c.keyLogMatches("", rawConn.RemoteAddr()) {
+		return c.keyLogged.ServerHandshake(rawConn)
+	}
+	return c.TransportCredentials.ServerHandshake(rawConn)
+}
+
+// Implements credentials.TransportCredentials, the clone shares the key log
+// file and background work with c
+func (c *Credentials) Clone() credentials.TransportCredentials {
+	clone := *c
+	clone.TransportCredentials = c.TransportCredentials.Clone()
+	if c.keyLogged != nil {
+		clone.keyLogged = c.keyLogged.Clone()
+	}
+	return &clone
+}
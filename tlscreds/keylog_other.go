@@ -0,0 +1,12 @@
+//go:build !unix
+
+package tlscreds
+
+import "io/fs"
+
+// No O_NOFOLLOW or file owners here, the mode check is all we have
+const keyLogOpenFlags = 0
+
+func keyLogCheckOwner(fi fs.FileInfo) error {
+	return nil
+}
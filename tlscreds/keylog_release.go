@@ -0,0 +1,6 @@
+//go:build release
+
+package tlscreds
+
+// Release builds refuse KeyLogOptions
+const keyLogAllowed = false
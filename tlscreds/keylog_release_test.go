@@ -0,0 +1,22 @@
+//go:build release
+
+package tlscreds
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyLogRelease(t *testing.T) {
+	dir := t.TempDir()
+	ca := newDevCA(t)
+	issueFiles(t, ca, filepath.Join(dir, "client"), "client")
+	_, err := LoadClientCredentials(filepath.Join(dir, "client"), Options{
+		Trust:  ca.Trust(),
+		KeyLog: KeyLogOptions{Path: filepath.Join(dir, "keys.log")},
+	})
+	require.ErrorIs(t, err, ErrKeyLogDisabled)
+	require.NoFileExists(t, filepath.Join(dir, "keys.log"))
+}
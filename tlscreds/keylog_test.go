@@ -0,0 +1,82 @@
+//go:build !release
+
+package tlscreds
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyLog(t *testing.T) {
+	dir := t.TempDir()
+	ca := newDevCA(t)
+	issueFiles(t, ca, filepath.Join(dir, "server"), "server")
+	issueFiles(t, ca, filepath.Join(dir, "client"), "client")
+	server, err := LoadServerCredentials(filepath.Join(dir, "server"), Options{Trust: ca.Trust()})
+	require.NoError(t, err)
+	defer server.Close()
+
+	keyLogPath := filepath.Join(dir, "keys.log")
+	client, err := LoadClientCredentials(filepath.Join(dir, "client"), Options{
+		Trust:  ca.Trust(),
+		KeyLog: KeyLogOptions{Path: keyLogPath},
+	})
+	require.NoError(t, err)
+
+	_, _, err = handshake(t, server, client)
+	require.NoError(t, err)
+	_, _, err = handshake(t, server, client.Clone())
+	require.NoError(t, err)
+	require.NoError(t, client.Close())
+
+	fi, err := os.Stat(keyLogPath)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	keys, err := os.ReadFile(keyLogPath)
+	require.NoError(t, err)
+	require.Contains(t, string(keys), "CLIENT_HANDSHAKE_TRAFFIC_SECRET")
+
+	// Files other users can read are refused
+	require.NoError(t, os.Chmod(keyLogPath, 0644))
+	_, err = LoadClientCredentials(filepath.Join(dir, "client"), Options{
+		Trust:  ca.Trust(),
+		KeyLog: KeyLogOptions{Path: keyLogPath},
+	})
+	require.ErrorContains(t, err, "accessible by other users")
+}
+
+func TestKeyLogPeers(t *testing.T) {
+	dir := t.TempDir()
+	ca := newDevCA(t)
+	issueFiles(t, ca, filepath.Join(dir, "server"), "server")
+	issueFiles(t, ca, filepath.Join(dir, "client"), "client")
+	server, err := LoadServerCredentials(filepath.Join(dir, "server"), Options{Trust: ca.Trust()})
+	require.NoError(t, err)
+	defer server.Close()
+
+	// handshake dials the authority 127.0.0.1
+	for _, tc := range []struct {
+		peers  []string
+		logged bool
+	}{
+		{[]string{"10.1.2.3"}, false},
+		{[]string{"10.1.2.3", "127.0.0.1"}, true},
+	} {
+		keyLogPath := filepath.Join(t.TempDir(), "keys.log")
+		client, err := LoadClientCredentials(filepath.Join(dir, "client"), Options{
+			Trust:  ca.Trust(),
+			KeyLog: KeyLogOptions{Path: keyLogPath, Peers: tc.peers},
+		})
+		require.NoError(t, err)
+		_, _, err = handshake(t, server, client)
+		require.NoError(t, err)
+		require.NoError(t, client.Close())
+
+		keys, err := os.ReadFile(keyLogPath)
+		require.NoError(t, err)
+		require.Equal(t, tc.logged, len(keys) > 0, tc.peers)
+	}
+}
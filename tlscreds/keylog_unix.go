@@ -0,0 +1,24 @@
+//go:build unix
+
+package tlscreds
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// O_NONBLOCK so that a FIFO fails with ENXIO rather than blocking
+const keyLogOpenFlags = syscall.O_NOFOLLOW | syscall.O_NONBLOCK
+
+func keyLogCheckOwner(fi fs.FileInfo) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("unknown owner")
+	}
+	if int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("owned by uid %d, not us (%d)", st.Uid, os.Getuid())
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+//go:build unix && !release
+
+package tlscreds
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyLogRefusedFiles(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	require.NoError(t, os.WriteFile(secret, []byte("untouched"), 0600))
+
+	// A symlink, even to a file we own
+	link := filepath.Join(dir, "link.log")
+	require.NoError(t, os.Symlink(secret, link))
+	_, err := openKeyLog(link)
+	require.ErrorIs(t, err, syscall.ELOOP)
+	b, err := os.ReadFile(secret)
+	require.NoError(t, err)
+	require.Equal(t, "untouched", string(b))
+
+	_, err = openKeyLog(os.DevNull)
+	require.ErrorContains(t, err, "is not a regular file")
+
+	// Would block waiting for a reader without O_NONBLOCK
+	fifo := filepath.Join(dir, "fifo.log")
+	require.NoError(t, syscall.Mkfifo(fifo, 0600))
+	_, err = openKeyLog(fifo)
+	require.ErrorIs(t, err, syscall.ENXIO)
+
+	if os.Getuid() != 0 {
+		t.Skip("chown to another user needs root")
+	}
+	other := filepath.Join(dir, "other.log")
+	require.NoError(t, os.WriteFile(other, nil, 0600))
+	require.NoError(t, os.Chown(other, 1000, 1000))
+	_, err = openKeyLog(other)
+	require.ErrorContains(t, err, "owned by uid 1000")
+}
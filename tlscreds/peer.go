@@ -0,0 +1,80 @@
+package tlscreds
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/url"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// Returned when the gRPC context has no verified TLS peer certificate
+var ErrNoPeerCertificate = errors.New("no verified peer certificate")
+
+// Returns the peer's certificate from an incoming or outgoing RPC context,
+// only if it was verified against the trusted CAs during the handshake
+func PeerCertificate(ctx context.Context) (*x509.Certificate, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, ErrNoPeerCertificate
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil, ErrNoPeerCertificate
+	}
+	return info.State.VerifiedChains[0][0], nil
+}
+
+// The names a verified peer certificate vouches for
+type PeerIdentity struct {
+	Cert        *x509.Certificate
+	CommonName  string
+	DNSNames    []string
+	IPAddresses []net.IP
+	URIs        []*url.URL
+	SPIFFEID    *url.URL // Nil unless SPIFFEIDFromCertificate accepts the certificate
+}
+
+// Like "CN=api spiffe://example.org/api", for logs
+func (p *PeerIdentity) String() string {
+	parts := []string{"CN=" + p.CommonName}
+	for _, name := range p.DNSNames {
+		parts = append(parts, "DNS="+name)
+	}
+	for _, ip := range p.IPAddresses {
+		parts = append(parts, "IP="+ip.String())
+	}
+	for _, uri := range p.URIs {
+		parts = append(parts, uri.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// Describes a certificate's identities
+func NewPeerIdentity(cert *x509.Certificate) *PeerIdentity {
+	id := &PeerIdentity{
+		Cert:        cert,
+		CommonName:  cert.Subject.CommonName,
+		DNSNames:    cert.DNSNames,
+		IPAddresses: cert.IPAddresses,
+		URIs:        cert.URIs,
+	}
+	// Same rule as SPIFFE verification, so the allowlist can't accept what it would reject
+	if spiffeID, err := SPIFFEIDFromCertificate(cert); err == nil {
+		id.SPIFFEID = spiffeID
+	}
+	return id
+}
+
+// The identities of the verified peer of an RPC context
+func PeerIdentityFromContext(ctx context.Context) (*PeerIdentity, error) {
+	cert, err := PeerCertificate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewPeerIdentity(cert), nil
+}
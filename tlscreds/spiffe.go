@@ -0,0 +1,111 @@
+package tlscreds
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+)
+
+// Verifies peers by the SPIFFE ID in their URI SAN instead of by hostname,
+// the way service meshes identify workloads.  The certificate must still
+// chain to a CA from TrustOptions, and must have exactly one URI SAN, as
+// X509-SVIDs do
+// NOTE: Clients do the chain verification themselves in this mode, so the
+// client side TLS state has no VerifiedChains, and PeerCertificate only
+// works on the server side
+type SPIFFEOptions struct {
+	// Accept any ID in this trust domain, like "example.org"
+	TrustDomain string
+	// Accept exactly these IDs, like "spiffe://example.org/ns/prod/sa/api"
+	IDs []string
+}
+
+func (o SPIFFEOptions) enabled() bool {
+	return o.TrustDomain != "" || len(o.IDs) > 0
+}
+
+// Returns the SPIFFE ID of an X509-SVID
+func SPIFFEIDFromCertificate(cert *x509.Certificate) (*url.URL, error) {
+	if len(cert.URIs) != 1 {
+		return nil, fmt.Errorf("expected exactly one URI SAN, found %d", len(cert.URIs))
+	}
+	id := cert.URIs[0]
+	if id.Scheme != "spiffe" || id.Host == "" || id.User != nil || id.RawQuery != "" || id.Fragment != "" {
+		return nil, fmt.Errorf("not a SPIFFE ID: %+q", id.String())
+	}
+	return id, nil
+}
+
+func (o SPIFFEOptions) check(cert *x509.Certificate) error {
+	id, err := SPIFFEIDFromCertificate(cert)
+	if err != nil {
+		return err
+	}
+	if id.Host == o.TrustDomain || slices.Contains(o.IDs, id.String()) {
+		return nil
+	}
+	return fmt.Errorf("SPIFFE ID %s is not accepted", id)
+}
+
+// Checks done in tls.Config.VerifyPeerCertificate, after (or for SPIFFE
+// clients, instead of) the standard verification
+type peerVerifier struct {
+	// Set when the standard verification is skipped, the chain is verified
+	// against these without checking the hostname
+	roots *x509.CertPool
+	usage x509.ExtKeyUsage
+
+	spiffe SPIFFEOptions
+	crls   *crlSet
+}
+
+func (v *peerVerifier) needed() bool {
+	return v.roots != nil || v.spiffe.enabled() || v.crls != nil
+}
+
+func (v *peerVerifier) verifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if v.roots != nil {
+		chains, err := verifyWithoutHostname(rawCerts, v.roots, v.usage)
+		if err != nil {
+			return err
+		}
+		verifiedChains = chains
+	}
+	if len(verifiedChains) == 0 {
+		return errors.New("no verified certificate chain")
+	}
+	if v.spiffe.enabled() {
+		if err := v.spiffe.check(verifiedChains[0][0]); err != nil {
+			return err
+		}
+	}
+	if v.crls != nil {
+		return v.crls.check(verifiedChains)
+	}
+	return nil
+}
+
+func verifyWithoutHostname(rawCerts [][]byte, roots *x509.CertPool, usage x509.ExtKeyUsage) ([][]*x509.Certificate, error) {
+	if len(rawCerts) == 0 {
+		return nil, errors.New("peer sent no certificate")
+	}
+	certs := []*x509.Certificate{}
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	return certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+}
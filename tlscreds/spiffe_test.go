@@ -0,0 +1,106 @@
+package tlscreds
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/credentials"
+)
+
+func TestServerName(t *testing.T) {
+	ca := newDevCA(t)
+	serverLeaf, err := ca.Issue(LeafOptions{CommonName: "server", DNSNames: []string{"api.internal"}, Server: true})
+	require.NoError(t, err)
+	clientLeaf, err := ca.Issue(LeafOptions{CommonName: "client", Client: true})
+	require.NoError(t, err)
+	server, err := NewServerCredentials(serverLeaf.Cert, Options{Trust: ca.Trust()})
+	require.NoError(t, err)
+
+	// handshake dials 127.0.0.1, which the certificate isn't valid for
+	client, err := NewClientCredentials(clientLeaf.Cert, Options{Trust: ca.Trust()})
+	require.NoError(t, err)
+	_, _, err = handshake(t, server, client)
+	require.ErrorContains(t, err, "127.0.0.1")
+
+	client, err = NewClientCredentials(clientLeaf.Cert, Options{Trust: ca.Trust(), ServerName: "api.internal"})
+	require.NoError(t, err)
+	_, clientSaw, err := handshake(t, server, client)
+	require.NoError(t, err)
+	require.Equal(t, "server", peerCN(clientSaw))
+}
+
+func TestSPIFFE(t *testing.T) {
+	ca := newDevCA(t)
+	apiID := &url.URL{Scheme: "spiffe", Host: "example.org", Path: "/api"}
+	serverLeaf, err := ca.Issue(LeafOptions{CommonName: "server", URIs: []*url.URL{apiID}, Server: true})
+	require.NoError(t, err)
+	clientLeaf, err := ca.Issue(LeafOptions{CommonName: "client", URIs: []*url.URL{
+		{Scheme: "spiffe", Host: "example.org", Path: "/batch"},
+	}, Client: true})
+	require.NoError(t, err)
+
+	try := func(serverSPIFFE, clientSPIFFE SPIFFEOptions, clientTrust TrustOptions) (serverSaw, clientSaw credentials.AuthInfo, err error) {
+		t.Helper()
+		server, err := NewServerCredentials(serverLeaf.Cert, Options{Trust: ca.Trust(), SPIFFE: serverSPIFFE})
+		require.NoError(t, err)
+		client, err := NewClientCredentials(clientLeaf.Cert, Options{Trust: clientTrust, SPIFFE: clientSPIFFE})
+		require.NoError(t, err)
+		return handshake(t, server, client)
+	}
+
+	// No DNS or IP SANs, the hostname is not checked
+	serverSaw, clientSaw, err := try(
+		SPIFFEOptions{TrustDomain: "example.org"},
+		SPIFFEOptions{IDs: []string{apiID.String()}},
+		ca.Trust(),
+	)
+	require.NoError(t, err)
+	require.Equal(t, "client", peerCN(serverSaw))
+	require.Equal(t, "server", peerCN(clientSaw))
+
+	_, _, err = try(
+		SPIFFEOptions{TrustDomain: "example.org"},
+		SPIFFEOptions{IDs: []string{"spiffe://example.org/other"}},
+		ca.Trust(),
+	)
+	require.ErrorContains(t, err, "SPIFFE ID spiffe://example.org/api is not accepted")
+
+	_, _, err = try(
+		SPIFFEOptions{TrustDomain: "other.org"},
+		SPIFFEOptions{TrustDomain: "example.org"},
+		ca.Trust(),
+	)
+	require.Error(t, err)
+
+	// The chain is still verified
+	_, _, err = try(
+		SPIFFEOptions{TrustDomain: "example.org"},
+		SPIFFEOptions{TrustDomain: "example.org"},
+		newDevCA(t).Trust(),
+	)
+	require.ErrorContains(t, err, "unknown authority")
+}
+
+func TestSPIFFEIDFromCertificate(t *testing.T) {
+	ca := newDevCA(t)
+	for _, tc := range []struct {
+		uris []*url.URL
+		err  string
+	}{
+		{[]*url.URL{{Scheme: "spiffe", Host: "example.org", Path: "/api"}}, ""},
+		{nil, "expected exactly one URI SAN, found 0"},
+		{[]*url.URL{{Scheme: "spiffe", Host: "a.org"}, {Scheme: "spiffe", Host: "b.org"}}, "expected exactly one URI SAN, found 2"},
+		{[]*url.URL{{Scheme: "https", Host: "example.org"}}, `not a SPIFFE ID: "https://example.org"`},
+	} {
+		leaf, err := ca.Issue(LeafOptions{URIs: tc.uris})
+		require.NoError(t, err)
+		id, err := SPIFFEIDFromCertificate(leaf.Cert.Leaf)
+		if tc.err != "" {
+			require.EqualError(t, err, tc.err)
+			continue
+		}
+		require.NoError(t, err)
+		require.Equal(t, tc.uris[0], id)
+	}
+}
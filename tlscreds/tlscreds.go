@@ -6,14 +6,18 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"time"
 
 	"google.golang.org/grpc/credentials"
 )
 
-// Set via linker parameters
+// Set via linker parameters, used when no TrustOptions are given
 var caCertPEM []byte
 
 // This loads the tls identity credentials from a part of files with the prefix identityPath
+// The result can be used by either a client or a server, prefer LoadServerCredentials
+// or LoadClientCredentials, which only set up the fields for their side, and
+// support key logging with Options.KeyLog
 func LoadCredentials(identityPath string) (credentials.TransportCredentials, error) {
 	certFilePath := identityPath + "-cert.pem"
 	keyFilePath := identityPath + "-key.pem"
@@ -23,45 +27,212 @@ func LoadCredentials(identityPath string) (credentials.TransportCredentials, err
 		"keyFilePath", keyFilePath,
 	)
 
-	cert, err := tls.LoadX509KeyPair(certFilePath, keyFilePath)
+	cert, err := loadKeyPair(certFilePath, keyFilePath)
 	if err != nil {
-		return nil, fmt.Errorf("loadX509KeyPair: %w", err)
+		return nil, err
 	}
 
-	// TODO: This is only used for clients, should probably add a flag to LoadCredentials
-	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	capool, err := TrustOptions{}.Pool()
 	if err != nil {
-		return nil, fmt.Errorf("x509.ParseCertificate-Leaf: %w", err)
-	}
-
-	capool := x509.NewCertPool()
-	if !capool.AppendCertsFromPEM(caCertPEM) {
-		return nil, fmt.Errorf("appendCertsFromPEM: %w", err)
+		return nil, err
 	}
 
 	// Note: some of these fields are only used by either the client or the server
 	tlsConfig := &tls.Config{
 		MinVersion:   tls.VersionTLS13,
-		Certificates: []tls.Certificate{cert},
+		Certificates: []tls.Certificate{*cert},
 		ClientAuth:   tls.RequireAndVerifyClientCert,
 		ClientCAs:    capool,
 		RootCAs:      capool,
-		// TODO: Allowing override of ServerName may be convienient
 		// Bundled test cert has the DNS name: 127.0.0.1, which means
 		// that you need to use that as your name in the client Dial
-		// options, but this is probably fine for now... the connection
-		// string is hardcoded anyway
+		// options, LoadClientCredentials has Options.ServerName to
+		// override that
+	}
+
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// Options for LoadServerCredentials and LoadClientCredentials
+type Options struct {
+	// When non zero, the cert and key files are checked this often, and the
+	// certificate is swapped in place when they change, so short lived
+	// certificates can be rotated without restarting anything
+	ReloadInterval time.Duration
+
+	// CA certificates that the peer's certificate must chain to
+	Trust TrustOptions
+
+	// Warnings and stats as the certificate approaches its expiry
+	Expiry ExpiryOptions
+
+	// CRL files (PEM or DER), peers with a certificate listed by its issuer
+	// are rejected during the handshake.  The files are re-read when they change
+	CRLFiles []string
+
+	// Debugging only, see KeyLogOptions
+	KeyLog KeyLogOptions
+
+	// Clients only: the name the server's certificate must have, instead
+	// of the authority that was dialed
+	ServerName string
+
+	// Verify peers by SPIFFE ID instead of hostname, see SPIFFEOptions
+	SPIFFE SPIFFEOptions
+}
+
+// TLS credentials for one side of a connection, usable with grpc.Creds or
+// grpc.WithTransportCredentials.  Close stops watching the files
+type Credentials struct {
+	credentials.TransportCredentials
+	identity *identity
+	expiry   *expiryMonitor
+
+	// Used instead of the embedded credentials for peers that match keyLogPeers
+	keyLogged   credentials.TransportCredentials
+	keyLogFile  *os.File
+	keyLogPeers []string
+}
+
+// The certificate currently being presented to peers
+func (c *Credentials) Certificate() *tls.Certificate {
+	return c.identity.current()
+}
+
+// Checks the cert and key files now, and swaps the certificate if they changed
+func (c *Credentials) Reload() error {
+	_, err := c.identity.reloadIfChanged()
+	return err
+}
+
+// Stops watching the files and the expiry, and closes the key log file
+// Connections that are already up aren't affected
+func (c *Credentials) Close() error {
+	c.identity.stop()
+	if c.expiry != nil {
+		c.expiry.stop()
+	}
+	if c.keyLogFile != nil {
+		return c.keyLogFile.Close()
+	}
+	return nil
+}
+
+// Loads credentials for a gRPC server from the files with the prefix identityPath
+// Clients must present a certificate signed by a trusted CA
+func LoadServerCredentials(identityPath string, opts Options) (*Credentials, error) {
+	slog.Debug("LoadServerCredentials", "identityPath", identityPath)
+	id, err := loadIdentity(identityPath)
+	if err != nil {
+		return nil, err
+	}
+	return serverCredentials(id, opts)
+}
+
+// Like LoadServerCredentials, but with a certificate that is already in memory
+func NewServerCredentials(cert *tls.Certificate, opts Options) (*Credentials, error) {
+	return serverCredentials(staticIdentity(cert), opts)
+}
+
+// Loads credentials for a gRPC client from the files with the prefix identityPath
+// The certificate is presented to servers that ask for one
+func LoadClientCredentials(identityPath string, opts Options) (*Credentials, error) {
+	slog.Debug("LoadClientCredentials", "identityPath", identityPath)
+	id, err := loadIdentity(identityPath)
+	if err != nil {
+		return nil, err
+	}
+	return clientCredentials(id, opts)
+}
+
+// Like LoadClientCredentials, but with a certificate that is already in memory
+func NewClientCredentials(cert *tls.Certificate, opts Options) (*Credentials, error) {
+	return clientCredentials(staticIdentity(cert), opts)
+}
+
+func serverCredentials(id *identity, opts Options) (*Credentials, error) {
+	capool, err := opts.Trust.Pool()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS13,
+		GetCertificate: id.getCertificate,
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+		ClientCAs:      capool,
+	}
+	return newCredentials(tlsConfig, id, opts)
+}
+
+func clientCredentials(id *identity, opts Options) (*Credentials, error) {
+	capool, err := opts.Trust.Pool()
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		MinVersion:           tls.VersionTLS13,
+		GetClientCertificate: id.getClientCertificate,
+		RootCAs:              capool,
+		ServerName:           opts.ServerName,
+		// The standard verification always checks the hostname, so
+		// peerVerifier verifies the chain instead
+		InsecureSkipVerify: opts.SPIFFE.enabled(),
+	}
+	return newCredentials(tlsConfig, id, opts)
+}
+
+func newCredentials(tlsConfig *tls.Config, id *identity, opts Options) (_ *Credentials, retErr error) {
+	if opts.ReloadInterval > 0 && id.certFilePath == "" {
+		return nil, fmt.Errorf("ReloadInterval needs credentials loaded from files")
 	}
 
-	if keyLogPath := os.Getenv("INSECURE_DEBUG_KEYLOGGING_PATH"); keyLogPath != "" {
-		slog.Warn("INSECURE_DEBUG_KEYLOGGING_PATH is set. Security is compromised")
-		keyLogFile, err := os.Create(keyLogPath)
-		if err != nil {
-			return nil, fmt.Errorf("keylog create file: %w", err)
+	verifier := &peerVerifier{spiffe: opts.SPIFFE}
+	if tlsConfig.InsecureSkipVerify {
+		verifier.roots = tlsConfig.RootCAs
+		verifier.usage = x509.ExtKeyUsageServerAuth
+	}
+	if len(opts.CRLFiles) > 0 {
+		var err error
+		if verifier.crls, err = loadCRLSet(opts.CRLFiles); err != nil {
+			return nil, err
 		}
-		tlsConfig.KeyLogWriter = keyLogFile
+	}
+	if verifier.needed() {
+		tlsConfig.VerifyPeerCertificate = verifier.verifyPeerCertificate
+	}
 
+	c := &Credentials{TransportCredentials: credentials.NewTLS(tlsConfig), identity: id}
+	defer func() {
+		if retErr != nil {
+			c.Close()
+		}
+	}()
+	if opts.KeyLog.Path != "" {
+		if err := c.enableKeyLog(tlsConfig, opts.KeyLog); err != nil {
+			return nil, err
+		}
 	}
+	if opts.Expiry.enabled() {
+		var err error
+		if c.expiry, err = startExpiryMonitor(id, opts.Expiry); err != nil {
+			return nil, err
+		}
+	}
+	if opts.ReloadInterval > 0 {
+		id.watch(opts.ReloadInterval)
+	}
+	return c, nil
+}
 
-	return credentials.NewTLS(tlsConfig), nil
+// Loads a cert/key pair, with Leaf filled in
+func loadKeyPair(certFilePath, keyFilePath string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFilePath, keyFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("loadX509KeyPair: %w", err)
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, fmt.Errorf("x509.ParseCertificate-Leaf: %w", err)
+	}
+	return &cert, nil
 }
@@ -0,0 +1,151 @@
+package tlscreds
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newDevCA(t *testing.T) *DevCA {
+	t.Helper()
+	ca, err := NewDevCA(t.Name())
+	require.NoError(t, err)
+	return ca
+}
+
+// Writes identityPath-cert.pem and identityPath-key.pem, valid for 127.0.0.1
+func issueFiles(t *testing.T, ca *DevCA, identityPath string, cn string) {
+	t.Helper()
+	leaf, err := ca.Issue(LeafOptions{CommonName: cn, IPAddresses: []net.IP{net.ParseIP("127.0.0.1")}})
+	require.NoError(t, err)
+	require.NoError(t, leaf.WriteFiles(identityPath))
+}
+
+func withLinkedCA(t *testing.T, caPEM []byte) {
+	old := caCertPEM
+	caCertPEM = caPEM
+	t.Cleanup(func() { caCertPEM = old })
+}
+
+// Runs a handshake over bufconn, returns what each side saw of the other
+func handshake(t *testing.T, server, client credentials.TransportCredentials) (serverSaw, clientSaw credentials.AuthInfo, err error) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	lis := bufconn.Listen(1 << 16)
+	defer lis.Close()
+
+	type result struct {
+		info credentials.AuthInfo
+		err  error
+	}
+	serverDone := make(chan result, 1)
+	go func() {
+		sConn, err := lis.Accept()
+		if err != nil {
+			serverDone <- result{nil, err}
+			return
+		}
+		defer sConn.Close()
+		_, info, err := server.ServerHandshake(sConn)
+		serverDone <- result{info, err}
+	}()
+
+	cConn, err := lis.DialContext(ctx)
+	require.NoError(t, err)
+	defer cConn.Close()
+	_, clientSaw, err = client.ClientHandshake(ctx, "127.0.0.1", cConn)
+	r := <-serverDone
+	if err == nil {
+		err = r.err
+	}
+	return r.info, clientSaw, err
+}
+
+func peerCN(info credentials.AuthInfo) string {
+	return info.(credentials.TLSInfo).State.PeerCertificates[0].Subject.CommonName
+}
+
+func TestServerClientCredentials(t *testing.T) {
+	dir := t.TempDir()
+	ca := newDevCA(t)
+	withLinkedCA(t, ca.CertPEM)
+	issueFiles(t, ca, filepath.Join(dir, "server"), "server")
+	issueFiles(t, ca, filepath.Join(dir, "client"), "client")
+
+	server, err := LoadServerCredentials(filepath.Join(dir, "server"), Options{})
+	require.NoError(t, err)
+	defer server.Close()
+	client, err := LoadClientCredentials(filepath.Join(dir, "client"), Options{})
+	require.NoError(t, err)
+	defer client.Close()
+
+	serverSaw, clientSaw, err := handshake(t, server, client)
+	require.NoError(t, err)
+	require.Equal(t, "client", peerCN(serverSaw))
+	require.Equal(t, "server", peerCN(clientSaw))
+
+	// Clients from another CA are refused
+	other := newDevCA(t)
+	issueFiles(t, other, filepath.Join(dir, "stranger"), "stranger")
+	stranger, err := LoadClientCredentials(filepath.Join(dir, "stranger"), Options{})
+	require.NoError(t, err)
+	defer stranger.Close()
+	_, _, err = handshake(t, server, stranger)
+	require.Error(t, err)
+
+	// The combined credentials still work for both sides
+	legacy, err := LoadCredentials(filepath.Join(dir, "client"))
+	require.NoError(t, err)
+	_, _, err = handshake(t, server, legacy)
+	require.NoError(t, err)
+}
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	ca := newDevCA(t)
+	withLinkedCA(t, ca.CertPEM)
+	serverPath := filepath.Join(dir, "server")
+	issueFiles(t, ca, serverPath, "server-1")
+	issueFiles(t, ca, filepath.Join(dir, "client"), "client")
+
+	server, err := LoadServerCredentials(serverPath, Options{ReloadInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	defer server.Close()
+	client, err := LoadClientCredentials(filepath.Join(dir, "client"), Options{})
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, clientSaw, err := handshake(t, server, client)
+	require.NoError(t, err)
+	require.Equal(t, "server-1", peerCN(clientSaw))
+
+	// Rotate by renaming new files into place
+	issueFiles(t, ca, filepath.Join(dir, "next"), "server-2")
+	require.NoError(t, os.Rename(filepath.Join(dir, "next-key.pem"), serverPath+"-key.pem"))
+	require.NoError(t, os.Rename(filepath.Join(dir, "next-cert.pem"), serverPath+"-cert.pem"))
+	require.Eventually(t, func() bool {
+		return server.Certificate().Leaf.Subject.CommonName == "server-2"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, clientSaw, err = handshake(t, server, client)
+	require.NoError(t, err)
+	require.Equal(t, "server-2", peerCN(clientSaw))
+
+	// A half written rotation keeps the old certificate
+	issueFiles(t, ca, filepath.Join(dir, "next"), "server-3")
+	require.NoError(t, os.Rename(filepath.Join(dir, "next-cert.pem"), serverPath+"-cert.pem"))
+	require.Error(t, server.Reload())
+	require.Equal(t, "server-2", server.Certificate().Leaf.Subject.CommonName)
+	require.NoError(t, os.Rename(filepath.Join(dir, "next-key.pem"), serverPath+"-key.pem"))
+	require.NoError(t, server.Reload())
+	require.Equal(t, "server-3", server.Certificate().Leaf.Subject.CommonName)
+}
@@ -0,0 +1,157 @@
+package tlscreds
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Returned when no CA certificates were found to verify peers with
+var ErrNoTrustAnchors = errors.New("no trust anchors configured")
+
+// Where the CA certificates that peers are verified against come from, all
+// the sources are combined.  When none are set, the CA linked into the
+// binary (caCertPEM) is used
+type TrustOptions struct {
+	// PEM bundles to read
+	Files []string
+	// Directories where every *.pem and *.crt file is read
+	Dirs []string
+	// Environment variables holding either PEM text, or a list of files
+	// separated by os.PathListSeparator, unset variables are skipped
+	EnvVars []string
+	// PEM text
+	PEM [][]byte
+	// Include the operating system's roots
+	System bool
+}
+
+// Replaced by tests, the real one is loaded once per process
+var systemCertPool = x509.SystemCertPool
+
+func (o TrustOptions) empty() bool {
+	return len(o.Files) == 0 && len(o.Dirs) == 0 && len(o.EnvVars) == 0 && len(o.PEM) == 0 && !o.System
+}
+
+// Builds the pool of trust anchors, returns an error wrapping
+// ErrNoTrustAnchors if the sources don't have any certificates
+func (o TrustOptions) Pool() (*x509.CertPool, error) {
+	if o.empty() {
+		if len(caCertPEM) == 0 {
+			return nil, fmt.Errorf("%w: set TrustOptions, or link caCertPEM into the binary", ErrNoTrustAnchors)
+		}
+		o.PEM = [][]byte{caCertPEM}
+	}
+
+	pool := x509.NewCertPool()
+	count := 0
+	if o.System {
+		system, err := systemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("system cert pool: %w", err)
+		}
+		pool = system
+		// Subjects is deprecated because it can't see roots that only the
+		// platform verifier knows about (macOS, Windows), there we can't tell
+		// whether there are any, so System alone counts as none.  On Linux
+		// the pool is read from the distribution's bundle, which is missing
+		// in scratch and distroless images
+		count += len(system.Subjects())
+	}
+
+	add := func(source string, pemBytes []byte) error {
+		n, err := appendPEM(pool, pemBytes)
+		if err != nil {
+			return fmt.Errorf("%s: %w", source, err)
+		}
+		if n == 0 {
+			return fmt.Errorf("%s: no certificates found", source)
+		}
+		count += n
+		return nil
+	}
+	addFile := func(path string) error {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return add(path, b)
+	}
+
+	for i, b := range o.PEM {
+		if err := add(fmt.Sprintf("PEM[%d]", i), b); err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range o.Files {
+		if err := addFile(path); err != nil {
+			return nil, err
+		}
+	}
+	for _, dir := range o.Dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			ext := filepath.Ext(e.Name())
+			if e.IsDir() || (ext != ".pem" && ext != ".crt") {
+				continue
+			}
+			if err := addFile(filepath.Join(dir, e.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, name := range o.EnvVars {
+		value, ok := os.LookupEnv(name)
+		if !ok || value == "" {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(value), "-----BEGIN") {
+			if err := add("$"+name, []byte(value)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, path := range filepath.SplitList(value) {
+			if err := addFile(path); err != nil {
+				return nil, fmt.Errorf("$%s: %w", name, err)
+			}
+		}
+	}
+
+	if count == 0 {
+		if o.System {
+			return nil, fmt.Errorf("%w: the system pool has no certificates that can be verified, and no other sources are set", ErrNoTrustAnchors)
+		}
+		return nil, fmt.Errorf("%w: the configured sources have no certificates", ErrNoTrustAnchors)
+	}
+	return pool, nil
+}
+
+// Like CertPool.AppendCertsFromPEM, but reports bad certificates instead of
+// skipping them, and returns how many were added
+func appendPEM(pool *x509.CertPool, pemBytes []byte) (int, error) {
+	n := 0
+	for {
+		var block *pem.Block
+		block, pemBytes = pem.Decode(pemBytes)
+		if block == nil {
+			return n, nil
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return n, fmt.Errorf("certificate %d: %w", n+1, err)
+		}
+		pool.AddCert(cert)
+		n++
+	}
+}
@@ -0,0 +1,77 @@
+package tlscreds
+
+import (
+	"crypto/x509"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustOptions(t *testing.T) {
+	dir := t.TempDir()
+	withLinkedCA(t, nil)
+
+	_, err := TrustOptions{}.Pool()
+	require.ErrorIs(t, err, ErrNoTrustAnchors)
+
+	caDir := filepath.Join(dir, "cas")
+	require.NoError(t, os.Mkdir(caDir, 0755))
+	_, err = TrustOptions{Dirs: []string{caDir}}.Pool()
+	require.ErrorIs(t, err, ErrNoTrustAnchors)
+
+	// One CA from each source, all of them are trusted together
+	fileCA, dirCA, envCA, pemCA := newDevCA(t), newDevCA(t), newDevCA(t), newDevCA(t)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file-ca.pem"), fileCA.CertPEM, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(caDir, "dir-ca.crt"), dirCA.CertPEM, 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(caDir, "README"), []byte("not a cert"), 0644))
+	t.Setenv("TLSCREDS_TEST_CA", string(envCA.CertPEM))
+	opts := TrustOptions{
+		Files:   []string{filepath.Join(dir, "file-ca.pem")},
+		Dirs:    []string{caDir},
+		EnvVars: []string{"TLSCREDS_TEST_CA", "TLSCREDS_TEST_UNSET"},
+		PEM:     [][]byte{pemCA.CertPEM},
+	}
+	pool, err := opts.Pool()
+	require.NoError(t, err)
+	for _, ca := range []*DevCA{fileCA, dirCA, envCA, pemCA} {
+		leaf, err := ca.Issue(LeafOptions{CommonName: "leaf"})
+		require.NoError(t, err)
+		_, err = leaf.Cert.Leaf.Verify(x509.VerifyOptions{Roots: pool, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+		require.NoError(t, err, ca.Cert.Subject.CommonName)
+	}
+
+	// Env vars can also name files
+	t.Setenv("TLSCREDS_TEST_CA", filepath.Join(dir, "file-ca.pem"))
+	_, err = TrustOptions{EnvVars: []string{"TLSCREDS_TEST_CA"}}.Pool()
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "empty.pem"), nil, 0644))
+	_, err = TrustOptions{Files: []string{filepath.Join(dir, "empty.pem")}}.Pool()
+	require.ErrorContains(t, err, "empty.pem: no certificates found")
+	_, err = TrustOptions{Files: []string{filepath.Join(dir, "missing.pem")}}.Pool()
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// The linked CA is only the fallback
+	withLinkedCA(t, fileCA.CertPEM)
+	_, err = TrustOptions{}.Pool()
+	require.NoError(t, err)
+}
+
+// Like a scratch or distroless image, where there is no CA bundle
+func TestTrustOptionsEmptySystemPool(t *testing.T) {
+	old := systemCertPool
+	systemCertPool = func() (*x509.CertPool, error) { return x509.NewCertPool(), nil }
+	t.Cleanup(func() { systemCertPool = old })
+
+	_, err := TrustOptions{System: true}.Pool()
+	require.ErrorIs(t, err, ErrNoTrustAnchors)
+	require.ErrorContains(t, err, "system pool")
+
+	ca := newDevCA(t)
+	pool, err := TrustOptions{System: true, PEM: [][]byte{ca.CertPEM}}.Pool()
+	require.NoError(t, err)
+	_, err = ca.Cert.Verify(x509.VerifyOptions{Roots: pool})
+	require.NoError(t, err)
+}